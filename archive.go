@@ -0,0 +1,309 @@
+package glitterboot
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// writeTarGz writes the files under dir, named relative to it, to w as a
+// gzip compressed tar archive. Directories are added with their contents.
+func writeTarGz(w io.Writer, dir string, names []string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, name := range names {
+		if err := addTarFile(tw, dir, name); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func addTarFile(tw *tar.Writer, dir, name string) error {
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.ToSlash(name)
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		_, err = io.Copy(tw, f)
+		return err
+	}
+
+	entries, err := f.Readdirnames(-1)
+	if err != nil {
+		return err
+	}
+	sort.Strings(entries)
+	for _, e := range entries {
+		if err := addTarFile(tw, dir, filepath.Join(name, e)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractTarGz extracts the gzip compressed tar archive src into dir.
+func extractTarGz(src, dir string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return errors.Errorf("%s: %v", src, err)
+	}
+	defer gr.Close()
+	return extractTar(gr, dir)
+}
+
+// extractTar extracts the directories and regular files of a tar stream
+// into dir. Entries that would land outside of dir are refused.
+func extractTar(r io.Reader, dir string) error {
+	return extractTarFunc(r, func(name string, isDir bool) (string, error) {
+		return filepath.Join(dir, name), nil
+	})
+}
+
+// extractTarFunc extracts the directories and regular files of a tar stream
+// to the path target returns for their relative name, skipping the entries
+// it returns no path for. Entries that would land outside of the archive
+// are refused before target is called.
+func extractTarFunc(r io.Reader, target func(name string, isDir bool) (string, error)) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return errors.Errorf("archive entry %q is outside of the target directory", hdr.Name)
+		}
+		path, err := target(name, hdr.Typeflag == tar.TypeDir)
+		if err != nil {
+			return err
+		}
+		if path == "" {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0755)
+		case tar.TypeReg:
+			err = writeExtracted(tr, path, hdr.FileInfo().Mode().Perm())
+		default:
+			err = errors.Errorf("archive entry %q has unsupported type %c", hdr.Name, hdr.Typeflag)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func writeExtracted(r io.Reader, target string, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Archive formats detected by archiveFormat.
+const (
+	archiveNone  = ""
+	archiveGzip  = "gzip"
+	archiveTarGz = "tar.gz"
+	archiveZip   = "zip"
+)
+
+// archiveFormat detects by content whether file is a zip, a gzip compressed
+// tar or a single gzip compressed file.
+func archiveFormat(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	n, _ := io.ReadFull(f, magic)
+	switch {
+	case bytes.HasPrefix(magic[:n], []byte("PK\x03\x04")):
+		return archiveZip, nil
+	case !bytes.HasPrefix(magic[:n], []byte{0x1f, 0x8b}):
+		return archiveNone, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return "", err
+	}
+	defer gr.Close()
+	// A tar header carries "ustar" at offset 257.
+	hdr := make([]byte, 262)
+	n, _ = io.ReadFull(gr, hdr)
+	if n == len(hdr) && bytes.Equal(hdr[257:262], []byte("ustar")) {
+		return archiveTarGz, nil
+	}
+	return archiveGzip, nil
+}
+
+// extractBinary replaces the archive at file with the binary it contains.
+// The binary is the entry at pathInArchive or else the only regular file
+// named name. Files that are not archives are left alone and false is
+// returned.
+func extractBinary(file, name, pathInArchive string) (bool, error) {
+	format, err := archiveFormat(file)
+	if err != nil || format == archiveNone {
+		return false, err
+	}
+
+	match := func(entry string) bool {
+		entry = path.Clean(strings.TrimPrefix(entry, "./"))
+		if pathInArchive != "" {
+			return entry == path.Clean(strings.TrimPrefix(pathInArchive, "./"))
+		}
+		return path.Base(entry) == name
+	}
+	tmp := file + ".extract"
+	defer os.Remove(tmp)
+
+	switch format {
+	case archiveGzip:
+		err = extractGzipFile(file, tmp)
+	case archiveTarGz:
+		err = extractTarGzEntry(file, tmp, match)
+	case archiveZip:
+		err = extractZipEntry(file, tmp, match)
+	}
+	if err != nil {
+		return true, errors.Errorf("%s archive %s: %v", format, file, err)
+	}
+	return true, os.Rename(tmp, file)
+}
+
+func extractGzipFile(src, dest string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	return writeExtracted(gr, dest, 0755)
+}
+
+func extractTarGzEntry(src, dest string, match func(name string) bool) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	found := ""
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || !match(hdr.Name) {
+			continue
+		}
+		if found != "" {
+			return errors.Errorf("both %s and %s match, select one with the path in archive flag", found, hdr.Name)
+		}
+		found = hdr.Name
+		if err := writeExtracted(tr, dest, 0755); err != nil {
+			return err
+		}
+	}
+	if found == "" {
+		return errors.New("binary not found")
+	}
+	return nil
+}
+
+func extractZipEntry(src, dest string, match func(name string) bool) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	var found *zip.File
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() || !match(f.Name) {
+			continue
+		}
+		if found != nil {
+			return errors.Errorf("both %s and %s match, select one with the path in archive flag", found.Name, f.Name)
+		}
+		found = f
+	}
+	if found == nil {
+		return errors.New("binary not found")
+	}
+	r, err := found.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return writeExtracted(r, dest, 0755)
+}
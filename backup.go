@@ -0,0 +1,487 @@
+package glitterboot
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	tmjson "github.com/tendermint/tendermint/libs/json"
+	"github.com/tendermint/tendermint/privval"
+)
+
+const (
+	backupVersion      = 1
+	backupManifestFile = "backup.json"
+)
+
+// backupManifest describes a node backup. The backup holds every path of
+// Files under its absolute path without the leading slash.
+type backupManifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	ChainID   string    `json:"chain_id,omitempty"`
+	NodeID    string    `json:"node_id,omitempty"`
+	// Data is set when the tendermint data and the glitter database at
+	// DBPath are included.
+	Data   bool     `json:"data"`
+	DBPath string   `json:"db_path,omitempty"`
+	Files  []string `json:"files"`
+}
+
+// backupFiles returns the files init installs and the store, and with
+// dbPath the data directories, leaving out what does not exist.
+func backupFiles(dbPath string) []string {
+	var dirs []string
+	if dbPath != "" {
+		dirs = []string{pathJoin(installdir, "tendermint/data"), dbPath}
+	}
+	inDirs := func(p string) bool {
+		for _, d := range dirs {
+			if p == d || strings.HasPrefix(p, d+string(filepath.Separator)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	candidates := []string{storedir, pathJoin(bootdir, "tendermint-validator.config.toml")}
+	for _, c := range installCopies(bootdir) {
+		// The installed binaries are enough, bootdir only stages them.
+		if !strings.HasPrefix(c.Dest, "/usr/bin/") {
+			candidates = append(candidates, c.Src)
+		}
+		candidates = append(candidates, c.Dest)
+	}
+	files := dirs
+	for _, p := range candidates {
+		if _, err := os.Stat(p); err == nil && !inDirs(p) {
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+// backupNode writes the keys, configs and store of the node to
+// args.BackupFile, and with args.BackupData its data directories while the
+// services are stopped.
+func backupNode(ctx context.Context, args NodeOpsArgs) error {
+	var (
+		m      backupManifest
+		active []string
+	)
+	p := newNodeOpsPipe(ctx, args)
+	p.Do("Check", func(ctx *setupNodeCtx) error {
+		if args.BackupFile == "" {
+			return errors.Wrap(ErrInvalidArgument, "backup: output file is required")
+		}
+		err := ctx.openStore(false)
+		if err != nil {
+			return err
+		}
+		done, err := ctx.store.Get(keyInitDone)
+		ctx.assert(err)
+		if done != "true" {
+			return errors.Wrap(ErrNotInitialized, "please init node first before backup")
+		}
+
+		m = backupManifest{Version: backupVersion, CreatedAt: time.Now().UTC(), Data: args.BackupData}
+		m.ChainID, err = ctx.store.Get(keyChainID)
+		ctx.assert(err)
+		m.NodeID, err = ctx.store.Get(keyNodeID)
+		ctx.assert(err)
+		if args.BackupData {
+			m.DBPath, err = glitterDBPath()
+			if err != nil {
+				return err
+			}
+		}
+		for _, f := range backupFiles(m.DBPath) {
+			m.Files = append(m.Files, strings.TrimPrefix(f, "/"))
+		}
+		return nil
+	})
+	if args.BackupData {
+		p.Do("Stop services", func(ctx *setupNodeCtx) error {
+			for _, unit := range []string{"tendermint", "glitter"} {
+				if unitActive(unit) {
+					active = append(active, unit)
+				}
+				if err := ctx.stopUnit(unit); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	p.Do("Write backup", func(ctx *setupNodeCtx) error {
+		if ctx.dryRun {
+			ctx.report(Event{Type: EventPlan, Message: "write /" + strings.Join(m.Files, ", /") + " to " + args.BackupFile})
+			return nil
+		}
+		return writeBackup(m, args.BackupFile)
+	})
+	if args.BackupData {
+		p.Do("Restart services", func(ctx *setupNodeCtx) error {
+			for _, unit := range active {
+				if err := ctx.exec.Systemctl(ctx.Context, "start", unit); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	p.Commit()
+	return p.Error()
+}
+
+// writeBackup writes the manifest and its files to dest, readable only by
+// the owner since it holds the private keys.
+func writeBackup(m backupManifest, dest string) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := dest + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	err = tw.WriteHeader(&tar.Header{Name: backupManifestFile, Mode: 0600, Size: int64(len(b)), ModTime: m.CreatedAt, Typeflag: tar.TypeReg})
+	if err == nil {
+		_, err = tw.Write(b)
+	}
+	for _, name := range m.Files {
+		if err == nil {
+			err = addTarFile(tw, "/", name)
+		}
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// restoreNode reinstalls a backup written by backupNode and gives the
+// files to the glitter user.
+func restoreNode(ctx context.Context, args NodeOpsArgs) error {
+	var (
+		m      backupManifest
+		stage  string
+		active []string
+		held   map[string]string
+	)
+	p := newNodeOpsPipe(ctx, args)
+	p.
+		Do("Check", func(ctx *setupNodeCtx) error {
+			if _, err := os.Stat(args.BackupFile); err != nil {
+				return errors.Wrap(ErrInvalidArgument, err.Error())
+			}
+			if err := checkUserGroup(glitterUser, glitterGroup); err != nil {
+				return errors.Wrapf(ErrInvalidArgument, "user %s and group %s must exist: %v", glitterUser, glitterGroup, err)
+			}
+
+			var err error
+			parent := ""
+			if !ctx.dryRun {
+				// Stage next to the installed files, data directories are
+				// moved into place.
+				err = os.MkdirAll(installdir, 0755)
+				ctx.assert(err)
+				parent = installdir
+			}
+			stage, err = ioutil.TempDir(parent, ".restore-")
+			ctx.assert(err)
+			ctx.onUndo("remove "+stage, func() error {
+				return os.RemoveAll(stage)
+			})
+			ctx.onCommit(func() error {
+				return os.RemoveAll(stage)
+			})
+			m, err = openBackup(args.BackupFile, stage)
+			if err != nil {
+				return err
+			}
+			if err := checkRestoreTarget(m, args.Force); err != nil {
+				return err
+			}
+			held, err = readHeldValidator()
+			return err
+		}).
+		Do("Stop services", func(ctx *setupNodeCtx) error {
+			for _, unit := range []string{"tendermint", "glitter"} {
+				if unitActive(unit) {
+					active = append(active, unit)
+				}
+				// Like init, a host without the units is fine.
+				ctx.stopUnit(unit)
+			}
+			return nil
+		}).
+		Do("Install files", func(ctx *setupNodeCtx) error {
+			for _, f := range m.Files {
+				src, dest := filepath.Join(stage, f), "/"+f
+				if err := keepNewerSignState(ctx, src, dest); err != nil {
+					return err
+				}
+				err := ctx.setAside(dest)
+				ctx.assert(err)
+				err = ctx.exec.MkdirAll(filepath.Dir(dest), 0755)
+				ctx.assert(err)
+				if fi, serr := os.Stat(src); serr == nil && fi.IsDir() {
+					err = ctx.exec.Rename(src, dest)
+				} else {
+					err = ctx.exec.CopyFile(CopyFileDesc{src, dest})
+				}
+				ctx.assert(err)
+			}
+			return nil
+		}).
+		Do("Hold imported validator", func(ctx *setupNodeCtx) error {
+			if held == nil {
+				return nil
+			}
+			keyFile := pathJoin(installdir, "tendermint/config", "priv_validator_key.json")
+			key, err := readValidatorKey(filepath.Join(stage, strings.TrimPrefix(keyFile, "/")))
+			ctx.assert(err)
+			if key != nil && key.Address.String() != held[keyPubKeyAddress] {
+				// The key of the backup replaced the imported one.
+				return nil
+			}
+			ctx.WorkDir = bootdir
+			ctx.StoreDir = storedir
+			err = ctx.openStore(false)
+			ctx.assert(err)
+			for _, k := range heldValidatorKeys {
+				err := ctx.store.Set(k, held[k])
+				ctx.assert(err)
+			}
+			return holdValidator(ctx)
+		}).
+		Do("Set ownership", func(ctx *setupNodeCtx) error {
+			owned := []string{installdir, "/usr/bin/glitter", "/usr/bin/tendermint"}
+			if m.DBPath != "" && !strings.HasPrefix(m.DBPath, installdir+string(filepath.Separator)) {
+				owned = append(owned, m.DBPath)
+			}
+			for _, p := range owned {
+				if _, err := os.Stat(p); err != nil && !ctx.dryRun {
+					continue
+				}
+				err := ctx.exec.Chown(p, glitterUser, glitterGroup, true)
+				ctx.assert(err)
+			}
+			ctx.exec.Chmod("/usr/bin/glitter", 0755)
+			ctx.exec.Chmod("/usr/bin/tendermint", 0755)
+			return ctx.exec.Systemctl(ctx.Context, "daemon-reload")
+		}).
+		Do("Restart services", func(ctx *setupNodeCtx) error {
+			for _, unit := range active {
+				if err := ctx.exec.Systemctl(ctx.Context, "start", unit); err != nil {
+					return err
+				}
+			}
+			return nil
+		}).
+		Commit()
+	return p.Error()
+}
+
+// openBackup extracts the backup src into dir and checks that it only
+// holds paths a node backup has.
+func openBackup(src, dir string) (backupManifest, error) {
+	var m backupManifest
+	if err := extractTarGz(src, dir); err != nil {
+		return m, errors.Wrapf(ErrInvalidArgument, "backup %s: %v", src, err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, backupManifestFile))
+	if err != nil {
+		return m, errors.Wrapf(ErrInvalidArgument, "backup %s: %v", src, err)
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, errors.Wrapf(ErrInvalidArgument, "backup %s: %s: %v", src, backupManifestFile, err)
+	}
+	if m.Version != backupVersion {
+		return m, errors.Wrapf(ErrInvalidArgument, "backup %s: unsupported version %d", src, m.Version)
+	}
+
+	allowed := []string{installdir + "/"}
+	if m.DBPath != "" {
+		dbPath, err := backupDBPath(dir)
+		if err != nil {
+			return m, errors.Wrapf(ErrInvalidArgument, "backup %s: %v", src, err)
+		}
+		if m.DBPath != dbPath {
+			return m, errors.Wrapf(ErrInvalidArgument, "backup %s: refusing to restore db_path %q, the glitter config has %q", src, m.DBPath, dbPath)
+		}
+		allowed = append(allowed, dbPath)
+	}
+	for _, c := range installCopies(bootdir) {
+		allowed = append(allowed, c.Dest)
+	}
+	for _, f := range m.Files {
+		abs := "/" + f
+		if filepath.Clean(abs) != abs || !backupAllowed(abs, allowed) {
+			return m, errors.Wrapf(ErrInvalidArgument, "backup %s: refusing to restore %s", src, abs)
+		}
+		if _, err := os.Lstat(filepath.Join(dir, f)); err != nil {
+			return m, errors.Wrapf(ErrInvalidArgument, "backup %s: %s is missing", src, abs)
+		}
+		if strings.HasPrefix(abs, "/usr/bin/") {
+			if err := checkExecutable(filepath.Join(dir, f)); err != nil {
+				return m, errors.Wrapf(ErrInvalidArgument, "backup %s: %s: %v", src, abs, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+// backupDBPath returns the db_path of the installed glitter config and of
+// the one of the backup extracted in dir, failing unless they agree. The
+// db_path of the manifest is not trusted on its own, it could point
+// anywhere.
+func backupDBPath(dir string) (string, error) {
+	config := pathJoin(installdir, "glitter", "config.toml")
+	dbPath := ""
+	for _, c := range []string{config, filepath.Join(dir, strings.TrimPrefix(config, "/"))} {
+		if _, err := os.Stat(c); os.IsNotExist(err) {
+			continue
+		}
+		p, err := readDBPath(c)
+		if err != nil {
+			return "", err
+		}
+		if dbPath != "" && p != dbPath {
+			return "", errors.Errorf("db_path %q of the backup is not the installed %q", p, dbPath)
+		}
+		dbPath = p
+	}
+	if dbPath == "" {
+		return "", errors.New("no glitter config to check db_path against")
+	}
+	return dbPath, nil
+}
+
+// backupAllowed reports whether p is one of allowed or under one of the
+// allowed directories, which end with a slash.
+func backupAllowed(p string, allowed []string) bool {
+	for _, a := range allowed {
+		if p == a || (strings.HasSuffix(a, "/") && strings.HasPrefix(p, a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRestoreTarget refuses to restore over an initialized node unless
+// forced, and over a node of another chain in any case.
+func checkRestoreTarget(m backupManifest, force bool) error {
+	s, err := newFileStore(storedir, false)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	chainID, err := s.Get(keyChainID)
+	if err != nil {
+		return err
+	}
+	if chainID != "" && m.ChainID != "" && chainID != m.ChainID {
+		return errors.Wrapf(ErrNetworkMismatch, "node is initialized for chain %q, the backup is of %q", chainID, m.ChainID)
+	}
+	if !force {
+		return errors.Wrap(ErrAlreadyInitialized, "restore would replace the installed node, use --force")
+	}
+	return nil
+}
+
+// heldValidatorKeys are the store keys of a key imported by
+// migrate-validator import that restore keeps over the ones of the backup.
+var heldValidatorKeys = []string{keyMigratedSignHeight, keyMigratedUnitEnabled, keyPubKey, keyPubKeyAddress, keyValidatorStage}
+
+// readHeldValidator returns the heldValidatorKeys of the installed node
+// while an imported key has not passed the double sign check, nil
+// otherwise.
+func readHeldValidator() (map[string]string, error) {
+	s, err := newFileStore(storedir, false)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	held := map[string]string{}
+	for _, k := range heldValidatorKeys {
+		held[k], err = s.Get(k)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if held[keyMigratedSignHeight] == "" {
+		return nil, nil
+	}
+	return held, nil
+}
+
+// keepNewerSignState keeps the installed priv_validator_state.json when it
+// has signed later than the one in the backup, restoring an older one would
+// let the validator sign the same heights again.
+func keepNewerSignState(ctx *setupNodeCtx, src, dest string) error {
+	const name = "priv_validator_state.json"
+	switch {
+	case filepath.Base(dest) == name:
+	case dest == pathJoin(installdir, "tendermint/data"):
+		src, dest = filepath.Join(src, name), filepath.Join(dest, name)
+	default:
+		return nil
+	}
+	installed, err := readSignState(dest)
+	if err != nil || installed == nil {
+		return err
+	}
+	backup, err := readSignState(src)
+	if err != nil || backup == nil {
+		return err
+	}
+	if signedLater(installed, backup) {
+		ctx.warn("keeping %s, it signed height %d, the backup only %d", dest, installed.Height, backup.Height)
+		return copyFile(CopyFileDesc{dest, src})
+	}
+	return nil
+}
+
+// readSignState returns the validator sign state in file, nil if there is
+// none.
+func readSignState(file string) (*privval.FilePVLastSignState, error) {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s privval.FilePVLastSignState
+	if err := tmjson.Unmarshal(b, &s); err != nil {
+		return nil, errors.Errorf("%s: %v", file, err)
+	}
+	return &s, nil
+}
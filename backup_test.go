@@ -0,0 +1,74 @@
+package glitterboot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestOpenBackupDBPath(t *testing.T) {
+	tests := []struct {
+		name string
+		// installed and backedUp are the db_path of the installed glitter
+		// config and of the one in the backup, none when empty.
+		installed, backedUp string
+		manifest            string
+		ok                  bool
+	}{
+		{name: "db_path of the config", installed: "glitter", backedUp: "glitter", manifest: "glitter", ok: true},
+		{name: "fresh host", backedUp: "glitter", manifest: "glitter", ok: true},
+		{name: "manifest outside of the allowed roots", installed: "glitter", backedUp: "glitter", manifest: "/etc"},
+		{name: "backed up config outside of the allowed roots", installed: "glitter", backedUp: "/etc", manifest: "/etc"},
+		{name: "no config", manifest: "/etc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestInstall(t)
+			abs := func(p string) string {
+				if filepath.IsAbs(p) {
+					return p
+				}
+				return filepath.Join(installdir, p)
+			}
+			rel := func(p string) string { return strings.TrimPrefix(p, "/") }
+			config := filepath.Join(installdir, "glitter", "config.toml")
+			if tt.installed != "" {
+				writeTree(t, installdir, map[string]string{"glitter/config.toml": `db_path = "` + abs(tt.installed) + `"` + "\n"})
+			}
+
+			dbPath := abs(tt.manifest)
+			m := backupManifest{Version: backupVersion, Data: true, DBPath: dbPath, Files: []string{rel(dbPath)}}
+			b, err := json.Marshal(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := map[string]string{
+				backupManifestFile:            string(b),
+				rel(dbPath) + "/cron.d/job":   "* * * * * root true",
+				rel(dbPath) + "/index/old.db": "index",
+			}
+			if tt.backedUp != "" {
+				files[rel(config)] = `db_path = "` + abs(tt.backedUp) + `"` + "\n"
+			}
+			src := filepath.Join(t.TempDir(), "backup.tar.gz")
+			if err := ioutil.WriteFile(src, makeSnapshot(t, "gzip", files), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = openBackup(src, t.TempDir())
+			if !tt.ok {
+				if !errors.Is(err, ErrInvalidArgument) || !strings.Contains(err.Error(), "db_path") {
+					t.Fatalf("got %v, want %v about db_path", err, ErrInvalidArgument)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
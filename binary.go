@@ -0,0 +1,51 @@
+package glitterboot
+
+import (
+	"debug/elf"
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+// elfMachines maps GOARCH to the ELF machine of its executables.
+var elfMachines = map[string]elf.Machine{
+	"386":     elf.EM_386,
+	"amd64":   elf.EM_X86_64,
+	"arm":     elf.EM_ARM,
+	"arm64":   elf.EM_AARCH64,
+	"ppc64le": elf.EM_PPC64,
+	"riscv64": elf.EM_RISCV,
+	"s390x":   elf.EM_S390,
+}
+
+// checkExecutable verifies that file is an ELF executable for the host.
+func checkExecutable(file string) error {
+	f, err := elf.Open(file)
+	if err != nil {
+		return errors.Errorf("%s is not an ELF executable: %v", file, err)
+	}
+	defer f.Close()
+
+	if f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN {
+		return errors.Errorf("%s is an ELF %s, not an executable", file, f.Type)
+	}
+	if want, ok := elfMachines[runtime.GOARCH]; ok && f.Machine != want {
+		return errors.Errorf("%s is built for %s, the host needs %s", file, f.Machine, want)
+	}
+	return nil
+}
+
+// stepVerifyBinaries refuses to go on with binaries that can not run on the
+// host, e.g. an archive that was not extracted or a build for another arch.
+func stepVerifyBinaries(ctx *setupNodeCtx) error {
+	for _, name := range []string{"tendermint", "glitter"} {
+		file := pathJoin(ctx.WorkDir, name)
+		if ctx.dryRun {
+			ctx.report(Event{Type: EventPlan, Message: "verify " + file + " is an ELF executable for " + runtime.GOARCH})
+			continue
+		}
+		err := checkExecutable(file)
+		ctx.assert(err)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package glitterboot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	bundleVersion      = 1
+	bundleManifestFile = "bundle.json"
+	bundleGenesisFile  = "genesis.json"
+)
+
+// bundleManifest describes an offline bundle. The bundle is flat: the
+// binaries, the genesis, the templates under their own names and this
+// manifest, all listed in its SHA256SUMS.
+type bundleManifest struct {
+	Version             int       `json:"version"`
+	CreatedAt           time.Time `json:"created_at"`
+	OS                  string    `json:"os"`
+	Arch                string    `json:"arch"`
+	TendermintVersion   string    `json:"tendermint_version,omitempty"`
+	GlitterVersion      string    `json:"glitter_version,omitempty"`
+	TendermintBinaryURL string    `json:"tendermint_binary_url"`
+	GlitterBinaryURL    string    `json:"glitter_binary_url"`
+}
+
+// nodeBundle is an offline bundle extracted to dir.
+type nodeBundle struct {
+	dir      string
+	manifest bundleManifest
+	sums     map[string]string
+}
+
+func (b *nodeBundle) path(name string) string {
+	return filepath.Join(b.dir, name)
+}
+
+// openBundle extracts the bundle src into dir and verifies its files
+// against its SHA256SUMS.
+func openBundle(src, dir string) (*nodeBundle, error) {
+	if err := extractTarGz(src, dir); err != nil {
+		return nil, errors.Wrapf(ErrInvalidArgument, "bundle %s: %v", src, err)
+	}
+	b := &nodeBundle{dir: dir}
+
+	data, err := ioutil.ReadFile(b.path(bundleManifestFile))
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidArgument, "bundle %s: %v", src, err)
+	}
+	if err := json.Unmarshal(data, &b.manifest); err != nil {
+		return nil, errors.Wrapf(ErrInvalidArgument, "bundle %s: %s: %v", src, bundleManifestFile, err)
+	}
+	if b.manifest.Version != bundleVersion {
+		return nil, errors.Wrapf(ErrInvalidArgument, "bundle %s: unsupported version %d", src, b.manifest.Version)
+	}
+	if b.manifest.OS != runtime.GOOS || b.manifest.Arch != runtime.GOARCH {
+		return nil, errors.Wrapf(ErrInvalidArgument, "bundle %s is built for %s/%s, not %s/%s",
+			src, b.manifest.OS, b.manifest.Arch, runtime.GOOS, runtime.GOARCH)
+	}
+
+	data, err = ioutil.ReadFile(b.path(sha256SumsFile))
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidArgument, "bundle %s: %v", src, err)
+	}
+	b.sums = parseSHA256Sums(data)
+	for _, name := range []string{bundleManifestFile, "tendermint", "glitter", bundleGenesisFile} {
+		if _, ok := b.sums[name]; !ok {
+			return nil, errors.Wrapf(ErrInvalidArgument, "bundle %s: %s is not listed in %s", src, name, sha256SumsFile)
+		}
+	}
+	for name, digest := range b.sums {
+		actual, err := fileSHA256(b.path(name))
+		if err != nil {
+			return nil, errors.Wrapf(ErrInvalidArgument, "bundle %s: %v", src, err)
+		}
+		if actual != digest {
+			return nil, errors.Wrapf(ErrChecksumMismatch, "bundle %s: %s: expected sha256 %s, got %s", src, name, digest, actual)
+		}
+	}
+	return b, nil
+}
+
+// useBundle takes the binaries, genesis and templates of init from the
+// offline bundle instead of URLs and the cluster RPC.
+func useBundle(ctx *setupNodeCtx, args NodeOpsArgs) error {
+	if args.ReleaseManifest != "" || args.ReleaseIndex != "" || args.GlitterVersion != "" || args.TendermintVersion != "" {
+		return errors.Wrap(ErrInvalidArgument, "a bundle can not be combined with a release manifest, index or versions")
+	}
+
+	dir, err := ioutil.TempDir("", "glitter-boot-bundle-")
+	ctx.assert(err)
+	ctx.onUndo("remove "+dir, func() error {
+		return os.RemoveAll(dir)
+	})
+	ctx.onCommit(func() error {
+		return os.RemoveAll(dir)
+	})
+	b, err := openBundle(args.Bundle, dir)
+	if err != nil {
+		return err
+	}
+
+	for _, bin := range []struct {
+		name   string
+		digest *string
+	}{
+		{"tendermint", &ctx.TendermintBinarySHA256},
+		{"glitter", &ctx.GlitterBinarySHA256},
+	} {
+		if *bin.digest != "" && *bin.digest != b.sums[bin.name] {
+			return errors.Wrapf(ErrChecksumMismatch, "bundle %s: expected %s sha256 %s, got %s", args.Bundle, bin.name, *bin.digest, b.sums[bin.name])
+		}
+		*bin.digest = b.sums[bin.name]
+	}
+	ctx.TendermintBinaryURL = b.manifest.TendermintBinaryURL
+	ctx.GlitterBinaryURL = b.manifest.GlitterBinaryURL
+	ctx.TendermintVersion = b.manifest.TendermintVersion
+	ctx.GlitterVersion = b.manifest.GlitterVersion
+	// bundle create already extracted archives.
+	ctx.TendermintBinPathInArchive = ""
+	ctx.GlitterBinPathInArchive = ""
+	ctx.bundle = b
+	return nil
+}
+
+// template returns the named template, from the offline bundle if it has one.
+func (c *setupNodeCtx) template(name string) []byte {
+	if c.bundle != nil {
+		if _, ok := c.bundle.sums[name]; ok {
+			b, err := ioutil.ReadFile(c.bundle.path(name))
+			c.assert(err)
+			return b
+		}
+	}
+	return embeddedTemplates()[name]
+}
+
+// bundleCreate downloads everything init needs into an offline bundle.
+func bundleCreate(ctx context.Context, args NodeOpsArgs) error {
+	p := newNodeOpsPipe(ctx, args)
+	p.
+		Do("Prepare", func(ctx *setupNodeCtx) error {
+			if args.Bundle == "" {
+				return errors.Wrap(ErrInvalidArgument, "bundle: output file is required")
+			}
+			dir, err := ioutil.TempDir("", "glitter-boot-bundle-")
+			ctx.assert(err)
+			ctx.WorkDir = dir
+			ctx.onUndo("remove "+dir, func() error {
+				return os.RemoveAll(dir)
+			})
+			ctx.onCommit(func() error {
+				return os.RemoveAll(dir)
+			})
+
+			err = prepareBinaries(ctx, args)
+			if err != nil {
+				return err
+			}
+			err = prepareGenesis(ctx, args)
+			if err != nil {
+				return err
+			}
+			return prepareSeeds(ctx, args)
+		}).
+		Do("Download tendermint", func(ctx *setupNodeCtx) error {
+			_, err := downloadBinary(ctx, pathJoin(ctx.WorkDir, "tendermint"), "tendermint", ctx.TendermintBinaryURL, ctx.TendermintBinarySHA256, ctx.TendermintBinPathInArchive)
+			return err
+		}).
+		Do("Download glitter", func(ctx *setupNodeCtx) error {
+			_, err := downloadBinary(ctx, pathJoin(ctx.WorkDir, "glitter"), "glitter", ctx.GlitterBinaryURL, ctx.GlitterBinarySHA256, ctx.GlitterBinPathInArchive)
+			return err
+		}).
+		Do("Verify binaries", stepVerifyBinaries).
+		Do("Download genesis file", func(ctx *setupNodeCtx) error {
+			b, _, err := fetchGenesis(ctx)
+			if err != nil {
+				return err
+			}
+			return ioutil.WriteFile(pathJoin(ctx.WorkDir, bundleGenesisFile), b, 0644)
+		}).
+		Do("Write bundle", func(ctx *setupNodeCtx) error {
+			return writeBundle(ctx, args.Bundle)
+		}).
+		Commit()
+	return p.Error()
+}
+
+func writeBundle(ctx *setupNodeCtx, dest string) error {
+	names := []string{"tendermint", "glitter", bundleGenesisFile, bundleManifestFile}
+
+	for name, b := range embeddedTemplates() {
+		err := ioutil.WriteFile(pathJoin(ctx.WorkDir, name), b, 0644)
+		ctx.assert(err)
+		names = append(names, name)
+	}
+
+	m, err := json.MarshalIndent(bundleManifest{
+		Version:             bundleVersion,
+		CreatedAt:           time.Now().UTC(),
+		OS:                  runtime.GOOS,
+		Arch:                runtime.GOARCH,
+		TendermintVersion:   ctx.TendermintVersion,
+		GlitterVersion:      ctx.GlitterVersion,
+		TendermintBinaryURL: ctx.TendermintBinaryURL,
+		GlitterBinaryURL:    ctx.GlitterBinaryURL,
+	}, "", "  ")
+	ctx.assert(err)
+	err = ioutil.WriteFile(pathJoin(ctx.WorkDir, bundleManifestFile), m, 0644)
+	ctx.assert(err)
+
+	sort.Strings(names)
+	var sums bytes.Buffer
+	for _, name := range names {
+		digest, err := fileSHA256(pathJoin(ctx.WorkDir, name))
+		ctx.assert(err)
+		fmt.Fprintf(&sums, "%s  %s\n", digest, name)
+	}
+	err = ioutil.WriteFile(pathJoin(ctx.WorkDir, sha256SumsFile), sums.Bytes(), 0644)
+	ctx.assert(err)
+	names = append(names, sha256SumsFile)
+
+	tmp := dest + ".tmp"
+	f, err := os.Create(tmp)
+	ctx.assert(err)
+	defer os.Remove(tmp)
+	err = writeTarGz(f, ctx.WorkDir, names)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	ctx.assert(err)
+	return os.Rename(tmp, dest)
+}
@@ -0,0 +1,285 @@
+package glitterboot
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cachedir holds downloaded artifacts named by their sha256, so that later
+// inits and reinstalls of the same release do not download them again.
+var cachedir = filepath.Join(bootdir, "cache")
+
+// cacheIndexName is the file in cachedir mapping the URLs artifacts were
+// downloaded from to their sha256, so that downloads without a known digest
+// use the cache too once the server confirms the file did not change.
+const cacheIndexName = "urls.json"
+
+// cachedURL is the artifact downloaded from a URL and the ETag or
+// Last-Modified of the response it came in.
+type cachedURL struct {
+	SHA256    string `json:"sha256"`
+	Validator string `json:"validator"`
+}
+
+// CacheEntry is an artifact in the local cache.
+type CacheEntry struct {
+	SHA256  string    `json:"sha256"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	// Pruned is set when prune removed the entry.
+	Pruned bool `json:"pruned,omitempty"`
+}
+
+func cachePath(digest string) string {
+	return filepath.Join(cachedir, digest)
+}
+
+func cacheHas(digest string) bool {
+	fi, err := os.Stat(cachePath(digest))
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// cachePut copies src into the cache under digest, which the caller has
+// verified.
+func cachePut(src, digest string) error {
+	if cacheHas(digest) {
+		return nil
+	}
+	if err := os.MkdirAll(cachedir, 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := ioutil.TempFile(cachedir, ".add-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), cachePath(digest))
+}
+
+func readCacheIndex() (map[string]cachedURL, error) {
+	index := map[string]cachedURL{}
+	b, err := ioutil.ReadFile(filepath.Join(cachedir, cacheIndexName))
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, errors.Errorf("%s: %v", cacheIndexName, err)
+	}
+	return index, nil
+}
+
+func writeCacheIndex(index map[string]cachedURL) error {
+	b, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cachedir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(cachedir, ".index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(cachedir, cacheIndexName))
+}
+
+// cacheLookupURL returns the digest of the cached artifact downloaded from
+// url, empty if there is none or if the server does not answer a
+// conditional HEAD request on the validator of that download with 304 Not
+// Modified.
+func cacheLookupURL(ctx context.Context, client *http.Client, url string) string {
+	index, err := readCacheIndex()
+	if err != nil {
+		return ""
+	}
+	e := index[url]
+	if !validSHA256(e.SHA256) || e.Validator == "" || !cacheHas(e.SHA256) {
+		return ""
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return ""
+	}
+	if strings.HasPrefix(e.Validator, `"`) {
+		req.Header.Set("If-None-Match", e.Validator)
+	} else {
+		req.Header.Set("If-Modified-Since", e.Validator)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return ""
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		return ""
+	}
+	return e.SHA256
+}
+
+// cacheRecordURL records that the artifact downloaded from url has digest,
+// and validator, if any, as its ETag or Last-Modified.
+func cacheRecordURL(url, digest, validator string) error {
+	index, err := readCacheIndex()
+	if err != nil {
+		// Start over rather than keep a broken index.
+		index = map[string]cachedURL{}
+	}
+	e := cachedURL{SHA256: digest, Validator: validator}
+	if index[url] == e {
+		return nil
+	}
+	index[url] = e
+	return writeCacheIndex(index)
+}
+
+// cacheList returns the cached artifacts sorted by modification time.
+func cacheList() ([]CacheEntry, error) {
+	infos, err := ioutil.ReadDir(cachedir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []CacheEntry
+	for _, fi := range infos {
+		if !fi.Mode().IsRegular() || !validSHA256(fi.Name()) {
+			continue
+		}
+		entries = append(entries, CacheEntry{SHA256: fi.Name(), Size: fi.Size(), ModTime: fi.ModTime()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime.Before(entries[j].ModTime)
+	})
+	return entries, nil
+}
+
+func cacheListOps(ctx context.Context, args NodeOpsArgs) error {
+	p := newNodeOpsPipe(ctx, args)
+	p.Do("List cache", func(ctx *setupNodeCtx) error {
+		entries, err := cacheList()
+		ctx.assert(err)
+		for i := range entries {
+			ctx.report(Event{Type: EventCacheEntry, CacheEntry: &entries[i]})
+		}
+		return nil
+	})
+	return p.Error()
+}
+
+func cacheAddOps(ctx context.Context, args NodeOpsArgs) error {
+	p := newNodeOpsPipe(ctx, args)
+	p.Do("Add to cache", func(ctx *setupNodeCtx) error {
+		fi, err := os.Stat(args.CacheFile)
+		if err != nil {
+			return errors.Wrap(ErrInvalidArgument, err.Error())
+		}
+		if !fi.Mode().IsRegular() {
+			return errors.Wrapf(ErrInvalidArgument, "%s is not a regular file", args.CacheFile)
+		}
+		digest, err := fileSHA256(args.CacheFile)
+		ctx.assert(err)
+		if ctx.dryRun {
+			ctx.report(Event{Type: EventPlan, Message: "add " + args.CacheFile + " to the cache as " + digest})
+			return nil
+		}
+		ctx.assert(cachePut(args.CacheFile, digest))
+		ctx.report(Event{Type: EventCacheEntry, CacheEntry: &CacheEntry{SHA256: digest, Size: fi.Size(), ModTime: time.Now()}})
+		return nil
+	})
+	return p.Error()
+}
+
+// cachePruneOps removes the cached artifacts that the installed node does
+// not use, or all of them with PruneAll. PruneOlderThan spares the entries
+// added more recently.
+func cachePruneOps(ctx context.Context, args NodeOpsArgs) error {
+	p := newNodeOpsPipe(ctx, args)
+	p.Do("Prune cache", func(ctx *setupNodeCtx) error {
+		keep := map[string]bool{}
+		if !args.PruneAll {
+			err := ctx.openStore(false)
+			if err != nil && !errors.Is(err, ErrNotInitialized) {
+				return err
+			}
+			if err == nil {
+				for _, key := range []string{keyTendermintBinSHA256, keyGlitterBinSHA256} {
+					digest, err := ctx.store.Get(key)
+					ctx.assert(err)
+					keep[digest] = true
+				}
+			}
+		}
+
+		entries, err := cacheList()
+		ctx.assert(err)
+		for i := range entries {
+			e := &entries[i]
+			if keep[e.SHA256] || time.Since(e.ModTime) < args.PruneOlderThan {
+				continue
+			}
+			ctx.assert(ctx.exec.RemoveAll(cachePath(e.SHA256)))
+			if !ctx.dryRun {
+				e.Pruned = true
+				ctx.report(Event{Type: EventCacheEntry, CacheEntry: e})
+			}
+		}
+		if ctx.dryRun {
+			return nil
+		}
+
+		// Forget the URLs of the pruned artifacts.
+		index, err := readCacheIndex()
+		if err != nil {
+			ctx.warn("%v, removing it", err)
+			index = map[string]cachedURL{}
+		}
+		for url, e := range index {
+			if !cacheHas(e.SHA256) {
+				delete(index, url)
+			}
+		}
+		return writeCacheIndex(index)
+	})
+	return p.Error()
+}
@@ -0,0 +1,101 @@
+package glitterboot
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDownloadBinaryUnpinnedCache(t *testing.T) {
+	oldCache := cachedir
+	t.Cleanup(func() { cachedir = oldCache })
+
+	tests := []struct {
+		name string
+		// validator sets the ETag or Last-Modified of the response.
+		validator func(w http.ResponseWriter, version int)
+	}{
+		{"etag", func(w http.ResponseWriter, version int) {
+			w.Header().Set("ETag", `"v`+strconv.Itoa(version)+`"`)
+		}},
+		{"last modified", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cachedir = t.TempDir()
+			content := []byte("tendermint v1")
+			version := 1
+			modTime := time.Now().Add(-time.Hour)
+			full := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.validator != nil {
+					tt.validator(w, version)
+				}
+				rec := &statusRecorder{ResponseWriter: w}
+				http.ServeContent(rec, r, "tendermint", modTime, bytes.NewReader(content))
+				if r.Method == http.MethodGet && rec.status == http.StatusOK {
+					full++
+				}
+			}))
+			defer srv.Close()
+
+			dest := filepath.Join(t.TempDir(), "tendermint")
+			download := func(want string) {
+				t.Helper()
+				err := runTestStep(func(ctx *setupNodeCtx) error {
+					_, err := downloadBinary(ctx, dest, "tendermint", srv.URL+"/tendermint", "", "")
+					return err
+				})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				b, err := ioutil.ReadFile(dest)
+				if err != nil || string(b) != want {
+					t.Fatalf("got %q, %v, want %q", b, err, want)
+				}
+				if _, err := os.Stat(dest + validatorSuffix); !os.IsNotExist(err) {
+					t.Fatalf("validator of %s left behind: %v", dest, err)
+				}
+			}
+
+			download("tendermint v1")
+			download("tendermint v1")
+			if full != 1 {
+				t.Fatalf("unchanged binary downloaded %d times, want once", full)
+			}
+
+			// A new release behind the same URL.
+			content = []byte("tendermint v2")
+			version = 2
+			modTime = modTime.Add(time.Minute)
+			download("tendermint v2")
+			if full != 2 {
+				t.Fatalf("changed binary downloaded %d times, want twice", full)
+			}
+		})
+	}
+}
+
+// statusRecorder keeps the status code written to a ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
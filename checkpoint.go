@@ -0,0 +1,120 @@
+package glitterboot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// stepCheckpoint records finished steps in the store together with a hash of
+// the inputs they ran with, so an interrupted init can continue where it failed.
+type stepCheckpoint struct {
+	resume   bool
+	fromStep string
+	onlyStep string
+	hash     string
+
+	reached bool
+}
+
+func newStepCheckpoint(args NodeOpsArgs, steps []nodeOpsStep) (*stepCheckpoint, error) {
+	set := 0
+	for _, v := range []bool{args.Resume, args.FromStep != "", args.OnlyStep != ""} {
+		if v {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, errors.Wrap(ErrInvalidArgument, "only one of --resume, --from-step and --only-step can be used")
+	}
+
+	cp := &stepCheckpoint{resume: args.Resume}
+	for _, name := range []string{args.FromStep, args.OnlyStep} {
+		if name == "" {
+			continue
+		}
+		step, ok := findStep(steps, name)
+		if !ok {
+			return nil, errors.Wrapf(ErrInvalidArgument, "unknown step %q, available steps: %s", name, strings.Join(stepSlugs(steps), ", "))
+		}
+		if name == args.FromStep {
+			cp.fromStep = step
+		} else {
+			cp.onlyStep = step
+		}
+	}
+
+	hash, err := inputsHash(args)
+	if err != nil {
+		return nil, err
+	}
+	cp.hash = hash
+	return cp, nil
+}
+
+func (c *stepCheckpoint) skip(s store, step string) (bool, error) {
+	switch {
+	case c.onlyStep != "":
+		return step != c.onlyStep, nil
+	case c.fromStep != "":
+		if step == c.fromStep {
+			c.reached = true
+		}
+		return !c.reached, nil
+	case c.resume:
+		h, err := s.Get(keyStepDone + stepSlug(step))
+		if err != nil {
+			return false, err
+		}
+		return h == c.hash, nil
+	}
+	return false, nil
+}
+
+func (c *stepCheckpoint) done(s store, step string) error {
+	return s.Set(keyStepDone+stepSlug(step), c.hash)
+}
+
+// forget drops the checkpoint of a step whose effects were rolled back.
+func (c *stepCheckpoint) forget(s store, step string) {
+	s.Set(keyStepDone+stepSlug(step), "")
+}
+
+// inputsHash identifies the arguments a step ran with. Step selection flags
+// are left out so that resuming does not invalidate earlier checkpoints.
+func inputsHash(args NodeOpsArgs) (string, error) {
+	args.Resume = false
+	args.FromStep = ""
+	args.OnlyStep = ""
+	b, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func findStep(steps []nodeOpsStep, name string) (string, bool) {
+	for _, s := range steps {
+		if strings.EqualFold(s.Name, name) || stepSlug(s.Name) == strings.ToLower(name) {
+			return s.Name, true
+		}
+	}
+	return "", false
+}
+
+// stepSlug turns "Download glitter" into "download-glitter".
+func stepSlug(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), " ", "-")
+}
+
+func stepSlugs(steps []nodeOpsStep) []string {
+	slugs := make([]string, len(steps))
+	for i, s := range steps {
+		slugs[i] = stepSlug(s.Name)
+	}
+	return slugs
+}
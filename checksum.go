@@ -0,0 +1,81 @@
+package glitterboot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const sha256SumsFile = "SHA256SUMS"
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+func validSHA256(digest string) bool {
+	return sha256Pattern.MatchString(digest)
+}
+
+func fileSHA256(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lookupSHA256Sums fetches the SHA256SUMS file published next to binURL and
+// returns the digest listed for the binary.
+func lookupSHA256Sums(ctx context.Context, client *http.Client, binURL string) (string, error) {
+	u, err := url.Parse(binURL)
+	if err != nil {
+		return "", err
+	}
+	name := path.Base(u.Path)
+	u.Path = path.Join(path.Dir(u.Path), sha256SumsFile)
+	u.RawQuery = ""
+
+	b, err := httpGet(ctx, client, u.String())
+	if err != nil {
+		return "", errors.Errorf("failed to fetch %s: %v", u, err)
+	}
+	digest, ok := parseSHA256Sums(b)[name]
+	if !ok {
+		return "", errors.Errorf("%s is not listed in %s", name, u)
+	}
+	return digest, nil
+}
+
+// parseSHA256Sums parses the output of sha256sum: "<digest>  <name>", with
+// a '*' before the name for files hashed in binary mode.
+func parseSHA256Sums(b []byte) map[string]string {
+	sums := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		digest := strings.ToLower(fields[0])
+		if !validSHA256(digest) {
+			continue
+		}
+		sums[path.Base(strings.TrimPrefix(fields[1], "*"))] = digest
+	}
+	return sums
+}
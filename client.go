@@ -1,6 +1,11 @@
 package glitterboot
 
 import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
 	thttp "github.com/tendermint/tendermint/rpc/client/http"
 )
 
@@ -8,4 +13,30 @@ func NewTMClient(tendermintAddr string) (*thttp.HTTP, error) {
 	return thttp.New(tendermintAddr, "/websocket")
 }
 
+// newTMClient returns a client for tendermintAddr going through client, e.g.
+// to use a proxy or client certificates.
+func newTMClient(tendermintAddr string, client *http.Client) (*thttp.HTTP, error) {
+	return thttp.NewWithClient(tendermintAddr, "/websocket", client)
+}
+
 type TendermintClient = thttp.HTTP
+
+const rpcHealthTimeout = 10 * time.Second
+
+// checkRPCHealth fails unless the node behind c answers its health check
+// and is not catching up.
+func checkRPCHealth(ctx context.Context, c *thttp.HTTP) error {
+	ctx, cancel := context.WithTimeout(ctx, rpcHealthTimeout)
+	defer cancel()
+	if _, err := c.Health(ctx); err != nil {
+		return err
+	}
+	status, err := c.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if status.SyncInfo.CatchingUp {
+		return errors.New("node is catching up")
+	}
+	return nil
+}
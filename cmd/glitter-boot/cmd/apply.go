@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	glitterboot "github.com/glitternetwork/glitter-boot"
+	"github.com/spf13/cobra"
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply [plan file]",
+	Short: "execute a plan written by plan, refusing if the host changed since",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNodeOps(cmd, glitterboot.NodeOpsArgs{
+			Type:     glitterboot.OpsApply,
+			PlanFile: args[0],
+		}, "Apply plan successfully")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"fmt"
+
+	glitterboot "github.com/glitternetwork/glitter-boot"
+	"github.com/spf13/cobra"
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "write the keys, configs and store of the node, optionally its data, to an archive, see restore",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNodeOps(cmd, backupArgs, fmt.Sprintf("Backup written to %s", backupArgs.BackupFile))
+	},
+}
+
+var backupArgs = glitterboot.NodeOpsArgs{}
+
+func init() {
+	f := backupCmd.PersistentFlags()
+	f.StringVarP(&backupArgs.BackupFile, "output-file", "o", "node-backup.tar.gz", "Backup file to write")
+	f.BoolVarP(&backupArgs.BackupData, "data", "", false, "Also back up the tendermint data and the glitter database, stopping the services meanwhile")
+	f.BoolVarP(&backupArgs.DryRun, "dry-run", "", false, "Print what backup would do without changing the host")
+	backupArgs.Type = glitterboot.OpsBackup
+
+	rootCmd.AddCommand(backupCmd)
+}
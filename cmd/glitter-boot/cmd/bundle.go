@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+
+	glitterboot "github.com/glitternetwork/glitter-boot"
+	"github.com/spf13/cobra"
+)
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "manage offline bundles for hosts without internet access",
+}
+
+var bundleCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "download the binaries, genesis and templates init needs into a bundle, see init --bundle",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNodeOps(cmd, bundleCreateArgs, fmt.Sprintf("Bundle written to %s", bundleCreateArgs.Bundle))
+	},
+}
+
+var bundleCreateArgs = glitterboot.NodeOpsArgs{}
+
+func init() {
+	addReleaseFlags(bundleCreateCmd, &bundleCreateArgs)
+	addGenesisFlags(bundleCreateCmd, &bundleCreateArgs)
+	f := bundleCreateCmd.PersistentFlags()
+	f.StringVarP(&bundleCreateArgs.Seeds, "seeds", "", "", "Seeds split by ',' to fetch the genesis from, example(2e73e0491df978d11f3d928a36b635a4e94ef927@192.167.10.2:26656)")
+	f.StringSliceVarP(&bundleCreateArgs.ClusterRPC, "cluster-rpc", "", nil, "Cluster tendermint RPC endpoints to use instead of port 26657 of the seeds, example(https://rpc.example.com:443)")
+	f.StringVarP(&bundleCreateArgs.Bundle, "output-file", "o", "node-bundle.tar.gz", "Bundle file to write")
+	bundleCreateCmd.MarkPersistentFlagRequired("seeds")
+	bundleCreateArgs.Type = glitterboot.OpsBundleCreate
+
+	bundleCmd.AddCommand(bundleCreateCmd)
+	rootCmd.AddCommand(bundleCmd)
+}
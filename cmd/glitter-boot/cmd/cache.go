@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	glitterboot "github.com/glitternetwork/glitter-boot"
+	"github.com/spf13/cobra"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "manage the local cache of downloaded binaries",
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list cached artifacts by sha256",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNodeOps(cmd, glitterboot.NodeOpsArgs{
+			Type: glitterboot.OpsCacheList,
+		}, "")
+	},
+}
+
+var cacheAddCmd = &cobra.Command{
+	Use:   "add [file]",
+	Short: "add a file to the cache, e.g. a binary copied from another machine",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNodeOps(cmd, glitterboot.NodeOpsArgs{
+			Type:      glitterboot.OpsCacheAdd,
+			CacheFile: args[0],
+			DryRun:    cacheDryRun,
+		}, "Add to cache successfully")
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "remove cached artifacts not used by the installed node",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNodeOps(cmd, glitterboot.NodeOpsArgs{
+			Type:           glitterboot.OpsCachePrune,
+			PruneAll:       cachePruneArgs.PruneAll,
+			PruneOlderThan: cachePruneArgs.PruneOlderThan,
+			DryRun:         cacheDryRun,
+		}, "Prune cache successfully")
+	},
+}
+
+var (
+	cacheDryRun    bool
+	cachePruneArgs glitterboot.NodeOpsArgs
+)
+
+func init() {
+	cacheCmd.PersistentFlags().BoolVarP(&cacheDryRun, "dry-run", "", false, "Print what the command would do without changing the cache")
+
+	f := cachePruneCmd.Flags()
+	f.BoolVarP(&cachePruneArgs.PruneAll, "all", "", false, "Also remove the binaries of the installed node")
+	f.DurationVarP(&cachePruneArgs.PruneOlderThan, "older-than", "", 0, "Only remove entries older than this duration, example(720h)")
+
+	cacheCmd.AddCommand(cacheListCmd, cacheAddCmd, cachePruneCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
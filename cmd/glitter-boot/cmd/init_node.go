@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"time"
+
 	glitterboot "github.com/glitternetwork/glitter-boot"
 	"github.com/spf13/cobra"
 )
@@ -8,24 +10,68 @@ import (
 var initNodeCmd = &cobra.Command{
 	Use:   "init",
 	Short: "init node",
-	Run: func(cmd *cobra.Command, args []string) {
-		glitterboot.NodeOperate(cmd.Context(), initNodeArgs)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNodeOps(cmd, initNodeArgs, "Init node successfully")
 	},
 }
 
 var initNodeArgs = glitterboot.NodeOpsArgs{}
 
 func init() {
+	addInitNodeFlags(initNodeCmd, &initNodeArgs)
 	f := initNodeCmd.PersistentFlags()
-	f.StringVarP(&initNodeArgs.Seeds, "seeds", "", "", "Seeds split by ',' example(2e73e0491df978d11f3d928a36b635a4e94ef927@192.167.10.2:26656)")
-	f.StringVarP(&initNodeArgs.Moniker, "moniker", "", "", "Moniker for node")
-	f.StringVarP(&initNodeArgs.IndexMode, "indexer", "", "es", "IndexMode 'es' or 'kv'")
-
-	f.StringVarP(&initNodeArgs.GlitterBinaryURL, "glitter_bin_url", "", glitterBinURL, "Glitter Binary URL")
-	f.StringVarP(&initNodeArgs.TendermintBinaryURL, "tendermint_bin_url", "", tendermintBinURL, "Tendermint Binary URL")
+	f.BoolVarP(&initNodeArgs.DryRun, "dry-run", "", false, "Print what init would do without changing the host")
 	initNodeArgs.Type = glitterboot.OpsInit
 
-	initNodeCmd.MarkFlagRequired("seeds")
-	initNodeCmd.MarkFlagRequired("moniker")
 	rootCmd.AddCommand(initNodeCmd)
 }
+
+// addInitNodeFlags registers the flags shared by init and plan.
+func addInitNodeFlags(cmd *cobra.Command, args *glitterboot.NodeOpsArgs) {
+	f := cmd.PersistentFlags()
+	f.StringVarP(&args.Seeds, "seeds", "", "", "Seeds split by ',' example(2e73e0491df978d11f3d928a36b635a4e94ef927@192.167.10.2:26656)")
+	f.StringVarP(&args.Moniker, "moniker", "", "", "Moniker for node")
+	f.StringSliceVarP(&args.ClusterRPC, "cluster-rpc", "", nil, "Cluster tendermint RPC endpoints to use instead of port 26657 of the seeds, example(https://rpc.example.com:443)")
+	f.StringVarP(&args.IndexMode, "indexer", "", "es", "IndexMode 'es' or 'kv'")
+	f.StringVarP(&args.ImportValidatorKey, "import-validator-key", "", "", "Key file written by 'keys export' to install instead of generating a validator key")
+	f.StringVarP(&args.Bundle, "bundle", "", "", "Offline bundle written by 'bundle create' to install from instead of downloading")
+	addReleaseFlags(cmd, args)
+	addGenesisFlags(cmd, args)
+
+	f.BoolVarP(&args.StateSync, "state-sync", "", false, "State sync from a recent height verified against the cluster RPC instead of replaying from genesis")
+	f.DurationVarP(&args.StateSyncTrustPeriod, "state-sync-trust-period", "", 168*time.Hour, "Trust period of the node's state sync light client, about 2/3 of the unbonding time; the trust height is verified from genesis regardless")
+	f.BoolVarP(&args.SkipPreflight, "skip-preflight", "", false, "Do not check that the seeds are reachable and have the node IDs given")
+	f.BoolVarP(&args.Resume, "resume", "", false, "Skip steps finished by a previous init with the same arguments")
+	f.StringVarP(&args.FromStep, "from-step", "", "", "Rerun init starting at the given step, example(download-glitter)")
+	f.StringVarP(&args.OnlyStep, "only-step", "", "", "Rerun only the given step, example(render-tendermint-config)")
+
+	cmd.MarkPersistentFlagRequired("seeds")
+	cmd.MarkPersistentFlagRequired("moniker")
+}
+
+// addGenesisFlags registers the flags selecting and pinning the genesis,
+// shared by init, plan and bundle create.
+func addGenesisFlags(cmd *cobra.Command, args *glitterboot.NodeOpsArgs) {
+	f := cmd.PersistentFlags()
+	f.StringVarP(&args.GenesisFile, "genesis-file", "", "", "Genesis file to use instead of the one of the seed RPC")
+	f.StringVarP(&args.GenesisURL, "genesis-url", "", "", "Genesis URL to use instead of the one of the seed RPC")
+	f.StringVarP(&args.GenesisSHA256, "genesis-sha256", "", "", "Expected sha256 of the genesis file or url, or of the compacted genesis of the cluster RPC")
+	f.StringVarP(&args.ChainID, "chain-id", "", "", "Expected chain id of the genesis")
+}
+
+// addReleaseFlags registers the flags selecting the glitter and tendermint
+// binaries, shared by init, plan and bundle create.
+func addReleaseFlags(cmd *cobra.Command, args *glitterboot.NodeOpsArgs) {
+	f := cmd.PersistentFlags()
+	f.StringVarP(&args.GlitterBinaryURL, "glitter_bin_url", "", glitterBinURL, "Glitter Binary URL")
+	f.StringVarP(&args.TendermintBinaryURL, "tendermint_bin_url", "", tendermintBinURL, "Tendermint Binary URL")
+	f.StringVarP(&args.GlitterBinarySHA256, "glitter_bin_sha256", "", "", "Expected sha256 of the glitter binary")
+	f.StringVarP(&args.TendermintBinarySHA256, "tendermint_bin_sha256", "", "", "Expected sha256 of the tendermint binary")
+	f.StringVarP(&args.GlitterBinPathInArchive, "glitter_bin_path_in_archive", "", "", "Path of the glitter binary when the download is a tar.gz or zip archive, default the entry named glitter")
+	f.StringVarP(&args.TendermintBinPathInArchive, "tendermint_bin_path_in_archive", "", "", "Path of the tendermint binary when the download is a tar.gz or zip archive, default the entry named tendermint")
+	f.BoolVarP(&args.FetchSHA256Sums, "sha256sums", "", false, "Verify binaries against the SHA256SUMS file next to their URL when no sha256 is given")
+	f.StringVarP(&args.ReleaseManifest, "release-manifest", "", "", "Signed release manifest (URL or path) to take the binary URLs and sha256 from")
+	f.StringVarP(&args.GlitterVersion, "glitter-version", "", "", "Glitter release to install, resolved through the release manifest or index")
+	f.StringVarP(&args.TendermintVersion, "tendermint-version", "", "", "Tendermint release to install, resolved through the release manifest or index")
+	f.StringVarP(&args.ReleaseIndex, "release-index", "", "", "Release index (URL or path) to resolve versions when no release manifest is given")
+}
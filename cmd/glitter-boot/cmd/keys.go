@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+
+	glitterboot "github.com/glitternetwork/glitter-boot"
+	"github.com/spf13/cobra"
+)
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "manage the validator key of the node",
+}
+
+var keysExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "write the validator key, encrypted with a passphrase, for init --import-validator-key on another host",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNodeOps(cmd, keysExportArgs, fmt.Sprintf("Validator key written to %s", keysExportArgs.KeyFile))
+	},
+}
+
+var keysExportArgs = glitterboot.NodeOpsArgs{}
+
+func init() {
+	f := keysExportCmd.Flags()
+	f.StringVarP(&keysExportArgs.KeyFile, "output-file", "o", "validator-key.json", "Encrypted key file to write")
+	f.BoolVarP(&keysExportArgs.Encrypt, "encrypt", "", false, "Encrypt the key with a passphrase (scrypt and secretbox), required")
+	f.BoolVarP(&keysExportArgs.DryRun, "dry-run", "", false, "Check the key and passphrase without writing the file")
+	keysExportArgs.Type = glitterboot.OpsKeysExport
+
+	keysCmd.AddCommand(keysExportCmd)
+	rootCmd.AddCommand(keysCmd)
+}
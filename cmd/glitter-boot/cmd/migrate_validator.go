@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+
+	glitterboot "github.com/glitternetwork/glitter-boot"
+	"github.com/spf13/cobra"
+)
+
+var migrateValidatorCmd = &cobra.Command{
+	Use:   "migrate-validator",
+	Short: "move the validator to another host without double signing",
+}
+
+var migrateExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "stop tendermint for good and write the validator key and sign state, encrypted, on the old host",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNodeOps(cmd, migrateExportArgs,
+			fmt.Sprintf("Validator exported to %s, tendermint is stopped and disabled on this host", migrateExportArgs.KeyFile))
+	},
+}
+
+var migrateImportCmd = &cobra.Command{
+	Use:   "import [key file]",
+	Short: "install the validator key and sign state written by export on the new host, then run start validator",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		migrateImportArgs.ImportValidatorKey = args[0]
+		return runNodeOps(cmd, migrateImportArgs, "Validator imported, run 'start validator' to start signing")
+	},
+}
+
+var (
+	migrateExportArgs = glitterboot.NodeOpsArgs{}
+	migrateImportArgs = glitterboot.NodeOpsArgs{}
+)
+
+func init() {
+	f := migrateExportCmd.Flags()
+	f.StringVarP(&migrateExportArgs.KeyFile, "output-file", "o", "validator-migration.json", "Encrypted key file to write")
+	f.StringVarP(&migrateExportArgs.ChainID, "chain-id", "", "", "Refuse to export unless the node was initialized for this chain id")
+	f.BoolVarP(&migrateExportArgs.DryRun, "dry-run", "", false, "Print what export would do without changing the host")
+	migrateExportArgs.Encrypt = true
+	migrateExportArgs.Type = glitterboot.OpsMigrateExport
+
+	f = migrateImportCmd.Flags()
+	f.StringVarP(&migrateImportArgs.ChainID, "chain-id", "", "", "Refuse to import unless the node was initialized for this chain id")
+	f.BoolVarP(&migrateImportArgs.DryRun, "dry-run", "", false, "Print what import would do without changing the host")
+	migrateImportArgs.Type = glitterboot.OpsMigrateImport
+
+	migrateValidatorCmd.AddCommand(migrateExportCmd, migrateImportCmd)
+	rootCmd.AddCommand(migrateValidatorCmd)
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	glitterboot "github.com/glitternetwork/glitter-boot"
+	"github.com/pkg/errors"
+)
+
+var passphraseFile string
+
+// readPassphrase returns the passphrase of an encrypted key file, from
+// --passphrase-file or else asked on the terminal without echo. confirm asks
+// twice when the passphrase is being chosen. Canceling ctx, e.g. with Ctrl-C,
+// abandons the prompt.
+func readPassphrase(ctx context.Context, confirm bool) ([]byte, error) {
+	if passphraseFile != "" {
+		b, err := ioutil.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, errors.Wrap(glitterboot.ErrInvalidArgument, err.Error())
+		}
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			b = b[:i]
+		}
+		return bytes.TrimSuffix(b, []byte("\r")), nil
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	pass, err := promptPassphrase(ctx, in, "Passphrase: ")
+	if err != nil || !confirm {
+		return pass, err
+	}
+	again, err := promptPassphrase(ctx, in, "Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pass, again) {
+		return nil, errors.Wrap(glitterboot.ErrInvalidArgument, "the passphrases do not match")
+	}
+	return pass, nil
+}
+
+// promptPassphrase reads a line from stdin, hiding it when stdin is a
+// terminal. The prompt goes to stderr to keep JSON output clean. The read
+// runs aside so that a canceled ctx returns at once, with the echo back on.
+func promptPassphrase(ctx context.Context, in *bufio.Reader, prompt string) ([]byte, error) {
+	type result struct {
+		line []byte
+		err  error
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	restore := disableEcho(int(os.Stdin.Fd()))
+	done := make(chan result, 1)
+	go func() {
+		line, err := in.ReadBytes('\n')
+		done <- result{line, err}
+	}()
+	var r result
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+	}
+	restore()
+	fmt.Fprintln(os.Stderr)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if r.err != nil && len(r.line) == 0 {
+		return nil, errors.Wrap(glitterboot.ErrInvalidArgument, "no passphrase given on stdin, see --passphrase-file")
+	}
+	return bytes.TrimRight(r.line, "\r\n"), nil
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"strings"
+
+	glitterboot "github.com/glitternetwork/glitter-boot"
+	"github.com/spf13/cobra"
+)
+
+var peersCmd = &cobra.Command{
+	Use:   "peers",
+	Short: "work with tendermint peer lists",
+}
+
+var peersValidateCmd = &cobra.Command{
+	Use:   "validate [id@host:port,...]",
+	Short: "check the syntax of a peer list, e.g. the value of --seeds, and resolve its hosts",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNodeOps(cmd, glitterboot.NodeOpsArgs{
+			Type:  glitterboot.OpsPeersValidate,
+			Seeds: strings.Join(args, ","),
+		}, "Peers are valid")
+	},
+}
+
+func init() {
+	peersCmd.AddCommand(peersValidateCmd)
+	rootCmd.AddCommand(peersCmd)
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"fmt"
+
+	glitterboot "github.com/glitternetwork/glitter-boot"
+	"github.com/spf13/cobra"
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "write the plan of an init to a file for review, see apply",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNodeOps(cmd, planArgs, fmt.Sprintf("Plan written to %s", planArgs.PlanFile))
+	},
+}
+
+var planArgs = glitterboot.NodeOpsArgs{}
+
+func init() {
+	addInitNodeFlags(planCmd, &planArgs)
+	f := planCmd.PersistentFlags()
+	f.StringVarP(&planArgs.PlanFile, "output-file", "o", "plan.json", "Plan file to write")
+	planArgs.Type = glitterboot.OpsPlan
+
+	rootCmd.AddCommand(planCmd)
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	glitterboot "github.com/glitternetwork/glitter-boot"
+	"github.com/spf13/cobra"
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore [backup file]",
+	Short: "reinstall a node from an archive written by backup",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		restoreArgs.BackupFile = args[0]
+		return runNodeOps(cmd, restoreArgs, "Restore node successfully")
+	},
+}
+
+var restoreArgs = glitterboot.NodeOpsArgs{}
+
+func init() {
+	f := restoreCmd.PersistentFlags()
+	f.BoolVarP(&restoreArgs.Force, "force", "", false, "Replace the node already initialized on this host")
+	f.BoolVarP(&restoreArgs.DryRun, "dry-run", "", false, "Print what restore would do without changing the host")
+	restoreArgs.Type = glitterboot.OpsRestore
+
+	rootCmd.AddCommand(restoreCmd)
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	glitterboot "github.com/glitternetwork/glitter-boot"
+	"github.com/spf13/cobra"
+)
+
+var restoreSnapshotCmd = &cobra.Command{
+	Use:   "restore-snapshot",
+	Short: "replace the chain data with a snapshot archive instead of syncing from genesis",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNodeOps(cmd, restoreSnapshotArgs, "Restore snapshot successfully")
+	},
+}
+
+var restoreSnapshotArgs = glitterboot.NodeOpsArgs{}
+
+func init() {
+	f := restoreSnapshotCmd.PersistentFlags()
+	f.StringVarP(&restoreSnapshotArgs.SnapshotURL, "url", "", "", "Snapshot archive url, a tar.gz, tar.lz4 or tar of tendermint/data/ and glitter/")
+	f.StringVarP(&restoreSnapshotArgs.SnapshotSHA256, "sha256", "", "", "Expected sha256 of the snapshot archive")
+	f.BoolVarP(&restoreSnapshotArgs.FetchSHA256Sums, "sha256sums", "", false, "Take the sha256 from the SHA256SUMS file next to the snapshot url")
+	f.StringVarP(&restoreSnapshotArgs.ChainID, "chain-id", "", "", "Refuse to restore unless the node was initialized for this chain id")
+	f.BoolVarP(&restoreSnapshotArgs.DryRun, "dry-run", "", false, "Print what restore-snapshot would do without changing the host")
+	restoreSnapshotCmd.MarkPersistentFlagRequired("url")
+	restoreSnapshotArgs.Type = glitterboot.OpsRestoreSnapshot
+
+	rootCmd.AddCommand(restoreSnapshotCmd)
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	glitterboot "github.com/glitternetwork/glitter-boot"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// Process exit codes.
+const (
+	exitOK           = 0
+	exitFailure      = 1 // a step failed and retrying as is will not help
+	exitUsage        = 2 // invalid command line or arguments
+	exitPrecondition = 3 // the node is not in the state the command needs
+	exitRetryable    = 4 // a step failed on a transient error, e.g. network
+	exitCanceled     = 130
+)
+
+// Output formats selected by --output.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+func newReporter() glitterboot.Reporter {
+	if outputFormat == outputJSON {
+		return glitterboot.NewJSONReporter(os.Stdout)
+	}
+	return glitterboot.NewTextReporter(os.Stdout)
+}
+
+// runNodeOps runs a node operation and reports success on completion.
+// Failures are reported by Execute.
+func runNodeOps(cmd *cobra.Command, args glitterboot.NodeOpsArgs, success string) error {
+	r := newReporter()
+	args.Reporter = r
+	args.StepTimeout = stepTimeout
+	args.DownloadMirrors = downloadMirrors
+	args.DownloadRetries = downloadRetries
+	args.Proxy = proxy
+	args.CABundle = caBundle
+	args.ClientCert = clientCert
+	args.ClientKey = clientKey
+
+	ctx := cmd.Context()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	args.Passphrase = func(confirm bool) ([]byte, error) {
+		return readPassphrase(ctx, confirm)
+	}
+	if err := glitterboot.NodeOperate(ctx, args); err != nil {
+		return operationError{err}
+	}
+	if args.DryRun {
+		success = "Dry run finished, nothing was changed"
+	}
+	r.Report(glitterboot.Event{
+		Type:    glitterboot.EventResult,
+		Time:    time.Now(),
+		Message: success,
+		Result:  &glitterboot.Result{Success: true},
+	})
+	return nil
+}
+
+// reportError prints the error of a failed command, as a result event when
+// JSON output is selected.
+func reportError(err error) {
+	if outputFormat != outputJSON {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return
+	}
+	result := &glitterboot.Result{ExitCode: exitCode(err)}
+	var stepErr *glitterboot.StepError
+	if errors.As(err, &stepErr) {
+		result.Step = stepErr.Step
+		result.Retryable = stepErr.Retryable
+	}
+	newReporter().Report(glitterboot.Event{
+		Type:   glitterboot.EventResult,
+		Time:   time.Now(),
+		Error:  err.Error(),
+		Result: result,
+	})
+}
+
+// operationError marks an error returned by a node operation, as opposed to
+// one raised by cobra while parsing the command line.
+type operationError struct {
+	error
+}
+
+func (e operationError) Unwrap() error {
+	return e.error
+}
+
+// exitCode maps an error returned by a command to the process exit code.
+// Errors that do not come from glitterboot are raised by cobra while parsing
+// the command line.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var (
+		stepErr *glitterboot.StepError
+		opErr   operationError
+	)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return exitCanceled
+	case errors.Is(err, glitterboot.ErrInvalidArgument):
+		return exitUsage
+	case errors.Is(err, glitterboot.ErrNotInitialized),
+		errors.Is(err, glitterboot.ErrAlreadyInitialized),
+		errors.Is(err, glitterboot.ErrHostChanged),
+		errors.Is(err, glitterboot.ErrNetworkMismatch),
+		errors.Is(err, glitterboot.ErrDoubleSignRisk):
+		return exitPrecondition
+	case errors.As(err, &stepErr):
+		if stepErr.Retryable {
+			return exitRetryable
+		}
+		return exitFailure
+	case errors.As(err, &opErr):
+		// Not classified, e.g. an I/O error outside of a step.
+		return exitFailure
+	}
+	return exitUsage
+}
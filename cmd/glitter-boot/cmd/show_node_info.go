@@ -9,10 +9,10 @@ var showNodeInfoCmd = &cobra.Command{
 	Use:     "show-node-info",
 	Aliases: []string{"show-node-info", "show_node_info"},
 	Short:   "show node info",
-	Run: func(cmd *cobra.Command, args []string) {
-		glitterboot.NodeOperate(cmd.Context(), glitterboot.NodeOpsArgs{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNodeOps(cmd, glitterboot.NodeOpsArgs{
 			Type: glitterboot.OpsShowNodeInfo,
-		})
+		}, "")
 	},
 }
 
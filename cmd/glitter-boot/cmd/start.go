@@ -1,35 +1,44 @@
 package cmd
 
 import (
-	"fmt"
-
 	glitterboot "github.com/glitternetwork/glitter-boot"
 	"github.com/spf13/cobra"
 )
 
 var startCmd = &cobra.Command{
-	Use:   "start",
-	Short: "start [target: `fullnode` or `validator`]",
-	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) == 0 {
-			fmt.Println("please provide a target to start")
-			return
-		}
+	Use:       "start",
+	Short:     "start [target: `fullnode` or `validator`]",
+	ValidArgs: []string{"fullnode", "validator"},
+	Args:      cobra.ExactValidArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
 		switch args[0] {
 		case "fullnode":
-			glitterboot.NodeOperate(cmd.Context(), glitterboot.NodeOpsArgs{
-				Type: glitterboot.OpsStartFullNode,
-			})
-		case "validator":
-			glitterboot.NodeOperate(cmd.Context(), glitterboot.NodeOpsArgs{
-				Type: glitterboot.OpsStartValidator,
-			})
+			return runNodeOps(cmd, glitterboot.NodeOpsArgs{
+				Type:    glitterboot.OpsStartFullNode,
+				ChainID: startChainID,
+				DryRun:  startDryRun,
+			}, "Start fullnode successfully")
 		default:
-			fmt.Println("must start `fullnode` or `validator`")
+			return runNodeOps(cmd, glitterboot.NodeOpsArgs{
+				Type:       glitterboot.OpsStartValidator,
+				ChainID:    startChainID,
+				ClusterRPC: startClusterRPC,
+				DryRun:     startDryRun,
+			}, "Start validator successfully")
 		}
 	},
 }
 
+var (
+	startDryRun     bool
+	startChainID    string
+	startClusterRPC []string
+)
+
 func init() {
+	f := startCmd.PersistentFlags()
+	f.BoolVarP(&startDryRun, "dry-run", "", false, "Print what start would do without changing the host")
+	f.StringVarP(&startChainID, "chain-id", "", "", "Refuse to start unless the node was initialized for this chain id")
+	f.StringSliceVarP(&startClusterRPC, "cluster-rpc", "", nil, "Cluster tendermint RPC endpoints for the double sign check of a migrated validator, port 26657 of the seeds by default")
 	rootCmd.AddCommand(startCmd)
 }
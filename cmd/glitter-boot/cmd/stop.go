@@ -8,13 +8,23 @@ import (
 var stopCmd = &cobra.Command{
 	Use:   "stop",
 	Short: "stop glitter and tendermint services",
-	Run: func(cmd *cobra.Command, args []string) {
-		glitterboot.NodeOperate(cmd.Context(), glitterboot.NodeOpsArgs{
-			Type: glitterboot.OpsStopNode,
-		})
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNodeOps(cmd, glitterboot.NodeOpsArgs{
+			Type:    glitterboot.OpsStopNode,
+			ChainID: stopChainID,
+			DryRun:  stopDryRun,
+		}, "Stop node successfully")
 	},
 }
 
+var (
+	stopDryRun  bool
+	stopChainID string
+)
+
 func init() {
+	f := stopCmd.PersistentFlags()
+	f.BoolVarP(&stopDryRun, "dry-run", "", false, "Print what stop would do without changing the host")
+	f.StringVarP(&stopChainID, "chain-id", "", "", "Refuse to stop unless the node was initialized for this chain id")
 	rootCmd.AddCommand(stopCmd)
 }
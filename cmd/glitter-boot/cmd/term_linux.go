@@ -0,0 +1,21 @@
+package cmd
+
+import "golang.org/x/sys/unix"
+
+// disableEcho turns off the echo of the terminal fd, if it is one, and
+// returns a function turning it back on.
+func disableEcho(fd int) func() {
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return func() {}
+	}
+	old := *t
+	t.Lflag &^= unix.ECHO
+	t.Lflag |= unix.ICANON | unix.ISIG
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, t); err != nil {
+		return func() {}
+	}
+	return func() {
+		unix.IoctlSetTermios(fd, unix.TCSETS, &old)
+	}
+}
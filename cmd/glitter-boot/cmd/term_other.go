@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package cmd
+
+// disableEcho is only supported on linux, elsewhere the passphrase is
+// echoed; use --passphrase-file instead.
+func disableEcho(fd int) func() {
+	return func() {}
+}
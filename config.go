@@ -1,9 +1,9 @@
 package glitterboot
 
 import (
+	"bytes"
 	_ "embed"
 
-	"os"
 	"text/template"
 )
 
@@ -19,28 +19,40 @@ var tendermintServiceFile []byte
 //go:embed template/glitter.service
 var glitterServiceFile []byte
 
-func renderTendermintConfig(dest string, data map[string]interface{}) error {
-	t, err := template.New("tendermint").Parse(tendermintConfigTpl)
-	if err != nil {
-		return err
-	}
-	f, err := os.Create(dest)
-	if err != nil {
-		return err
+// Names of the templates, also used for their copies in an offline bundle.
+const (
+	tendermintConfigTemplate  = "tendermint.config.toml"
+	glitterConfigTemplate     = "glitter.config.toml"
+	tendermintServiceTemplate = "tendermint.service"
+	glitterServiceTemplate    = "glitter.service"
+)
+
+// embeddedTemplates returns the templates built into glitter-boot by name.
+func embeddedTemplates() map[string][]byte {
+	return map[string][]byte{
+		tendermintConfigTemplate:  []byte(tendermintConfigTpl),
+		glitterConfigTemplate:     []byte(glitterConfigTpl),
+		tendermintServiceTemplate: tendermintServiceFile,
+		glitterServiceTemplate:    glitterServiceFile,
 	}
-	defer f.Close()
-	return t.Execute(f, data)
 }
 
-func renderGlitterConfig(dest string, data map[string]interface{}) error {
-	t, err := template.New("glitter").Parse(glitterConfigTpl)
+func renderTendermintConfig(tpl string, data map[string]interface{}) ([]byte, error) {
+	t, err := template.New("tendermint").Parse(tpl)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	f, err := os.Create(dest)
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+	return buf.Bytes(), err
+}
+
+func renderGlitterConfig(tpl string, data map[string]interface{}) ([]byte, error) {
+	t, err := template.New("glitter").Parse(tpl)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer f.Close()
-	return t.Execute(f, data)
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+	return buf.Bytes(), err
 }
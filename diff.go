@@ -0,0 +1,66 @@
+package glitterboot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// maxDiffCells bounds the size of the table used by lineDiff.
+const maxDiffCells = 4 << 20
+
+func isBinary(b []byte) bool {
+	return bytes.IndexByte(b, 0) >= 0
+}
+
+// lineDiff returns the changed lines between the installed and the planned
+// content of name, each change prefixed with the line numbers it applies to.
+func lineDiff(name string, installed, planned []byte) string {
+	a := strings.Split(string(installed), "\n")
+	b := strings.Split(string(planned), "\n")
+	if len(a)*len(b) > maxDiffCells {
+		return fmt.Sprintf("%s differs (too large to diff)\n", name)
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s (installed)\n+++ %s (planned)\n", name, name)
+	i, j := 0, 0
+	inHunk := false
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			inHunk = false
+			i++
+			j++
+			continue
+		case !inHunk:
+			fmt.Fprintf(&sb, "@@ -%d +%d @@\n", i+1, j+1)
+			inHunk = true
+		}
+		if i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]) {
+			fmt.Fprintf(&sb, "-%s\n", a[i])
+			i++
+		} else {
+			fmt.Fprintf(&sb, "+%s\n", b[j])
+			j++
+		}
+	}
+	return sb.String()
+}
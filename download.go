@@ -0,0 +1,286 @@
+package glitterboot
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultDownloadRetries = 5
+	downloadBackoff        = time.Second
+	maxDownloadBackoff     = 30 * time.Second
+	progressInterval       = time.Second
+)
+
+// downloader fetches files into "<dest>.<url hash>.part", resuming partial files with
+// HTTP Range requests, and renames them into place once complete. A partial
+// file is only resumed with If-Range against the ETag or Last-Modified of the
+// response that started it, kept in "<part>.validator", so that the bytes of
+// a remote file that changed in between are never joined, and moved to
+// "<dest>.validator" once complete for the cache index. Transient
+// failures are retried with exponential backoff, trying every mirror in turn.
+type downloader struct {
+	client  *http.Client
+	mirrors []string
+	retries int
+	report  func(e Event)
+}
+
+func newDownloader(client *http.Client, mirrors []string, retries int, report func(e Event)) *downloader {
+	if retries < 0 {
+		retries = defaultDownloadRetries
+	}
+	return &downloader{
+		client:  client,
+		mirrors: mirrors,
+		retries: retries,
+		report:  report,
+	}
+}
+
+func (d *downloader) Download(ctx context.Context, dest string, rawURL string) error {
+	urls, err := mirrorURLs(rawURL, d.mirrors)
+	if err != nil {
+		return err
+	}
+
+	// The partial file is keyed by the URL so that a leftover of another
+	// release is never resumed.
+	sum := sha256.Sum256([]byte(rawURL))
+	part := fmt.Sprintf("%s.%x.part", dest, sum[:6])
+	backoff := downloadBackoff
+	var lastErr error
+	for attempt := 0; attempt <= d.retries; attempt++ {
+		if attempt > 0 {
+			d.report(Event{Type: EventWarn, Message: fmt.Sprintf("download failed: %v, retrying in %s", lastErr, backoff)})
+			if err := sleepContext(ctx, backoff); err != nil {
+				return err
+			}
+			if backoff *= 2; backoff > maxDownloadBackoff {
+				backoff = maxDownloadBackoff
+			}
+		}
+
+		retry := false
+		for _, u := range urls {
+			lastErr = d.fetch(ctx, part, u)
+			if lastErr == nil {
+				if err := os.Rename(part, dest); err != nil {
+					return err
+				}
+				return keepValidator(part, dest)
+			}
+			if ctx.Err() != nil {
+				return lastErr
+			}
+			retry = retry || isRetryable(lastErr)
+		}
+		if !retry {
+			break
+		}
+	}
+	return lastErr
+}
+
+// fetch downloads u into part, continuing after the bytes already there if
+// the remote file did not change since they were fetched.
+func (d *downloader) fetch(ctx context.Context, part, u string) error {
+	var offset int64
+	if fi, err := os.Stat(part); err == nil {
+		offset = fi.Size()
+	}
+	validator, _ := ioutil.ReadFile(part + validatorSuffix)
+	if offset > 0 && len(validator) == 0 {
+		// Nothing tells whether the remote file is still the same.
+		if err := os.Remove(part); err != nil {
+			return err
+		}
+		offset = 0
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", string(validator))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && contentRangeStart(resp) == offset:
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusOK:
+		// A new download, or the remote file changed since the partial one.
+		flags |= os.O_TRUNC
+		offset = 0
+		if err := saveValidator(part, resp); err != nil {
+			return err
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file does not fit the remote file, start over.
+		if err := os.Remove(part); err != nil {
+			return err
+		}
+		os.Remove(part + validatorSuffix)
+		return d.fetch(ctx, part, u)
+	default:
+		return &httpStatusError{URL: u, Status: resp.Status, Code: resp.StatusCode}
+	}
+
+	out, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	pw := &progressWriter{url: u, bytes: offset, total: total, report: d.report}
+	_, err = io.Copy(out, io.TeeReader(resp.Body, pw))
+	pw.finish(err == nil)
+	return err
+}
+
+// validatorSuffix names the file next to a partial download that keeps the
+// If-Range validator of its response.
+const validatorSuffix = ".validator"
+
+// saveValidator keeps the strong ETag, or else the Last-Modified date, of
+// resp for resuming part. Without either the partial file is not resumed.
+func saveValidator(part string, resp *http.Response) error {
+	v := resp.Header.Get("ETag")
+	if strings.HasPrefix(v, "W/") {
+		// If-Range only accepts strong validators.
+		v = ""
+	}
+	if v == "" {
+		v = resp.Header.Get("Last-Modified")
+	}
+	if v == "" {
+		err := os.Remove(part + validatorSuffix)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return ioutil.WriteFile(part+validatorSuffix, []byte(v), 0644)
+}
+
+// keepValidator moves the validator of the completed download part next to
+// dest, where downloadValidator finds it.
+func keepValidator(part, dest string) error {
+	err := os.Rename(part+validatorSuffix, dest+validatorSuffix)
+	if os.IsNotExist(err) {
+		err = os.Remove(dest + validatorSuffix)
+	}
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// downloadValidator returns and removes the ETag or Last-Modified of the
+// response the file at dest was downloaded from, empty if it had none.
+func downloadValidator(dest string) string {
+	b, _ := ioutil.ReadFile(dest + validatorSuffix)
+	os.Remove(dest + validatorSuffix)
+	return string(b)
+}
+
+// contentRangeStart returns the first byte position of a 206 response.
+func contentRangeStart(resp *http.Response) int64 {
+	v := strings.TrimPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	i := strings.IndexByte(v, '-')
+	if i < 0 {
+		return -1
+	}
+	n, err := strconv.ParseInt(v[:i], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// mirrorURLs returns rawURL followed by its location on every mirror. A
+// mirror replaces the scheme and host of rawURL and prefixes its path.
+func mirrorURLs(rawURL string, mirrors []string) ([]string, error) {
+	orig, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidArgument, "download url %s: %v", rawURL, err)
+	}
+	urls := []string{rawURL}
+	for _, m := range mirrors {
+		m = strings.TrimSpace(m)
+		if m == "" {
+			continue
+		}
+		mu, err := url.Parse(m)
+		if err != nil || mu.Host == "" {
+			return nil, errors.Wrapf(ErrInvalidArgument, "download mirror %q", m)
+		}
+		u := *orig
+		u.Scheme = mu.Scheme
+		u.Host = mu.Host
+		u.User = mu.User
+		u.Path = path.Join("/", mu.Path, orig.Path)
+		u.RawPath = ""
+		urls = append(urls, u.String())
+	}
+	return urls, nil
+}
+
+// progressWriter reports the progress of a download at most once per
+// progressInterval.
+type progressWriter struct {
+	url    string
+	bytes  int64
+	total  int64
+	last   time.Time
+	report func(e Event)
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	w.bytes += int64(len(b))
+	if time.Since(w.last) >= progressInterval {
+		w.last = time.Now()
+		w.send(false)
+	}
+	return len(b), nil
+}
+
+func (w *progressWriter) finish(ok bool) {
+	if ok {
+		w.total = w.bytes
+	}
+	w.send(true)
+}
+
+func (w *progressWriter) send(done bool) {
+	w.report(Event{Type: EventProgress, Progress: &Progress{
+		URL:   w.url,
+		Bytes: w.bytes,
+		Total: w.total,
+		Done:  done,
+	}})
+}
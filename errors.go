@@ -0,0 +1,117 @@
+package glitterboot
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidArgument is returned when the arguments of an operation are invalid.
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrNotInitialized is returned by operations that need an initialized node.
+	ErrNotInitialized = errors.New("node is not initialized")
+	// ErrAlreadyInitialized is returned by init when the node is already set up.
+	ErrAlreadyInitialized = errors.New("node is already initialized")
+	// ErrHostChanged is returned by apply when the host no longer matches the plan.
+	ErrHostChanged = errors.New("host state changed since the plan was created")
+	// ErrChecksumMismatch is returned when a downloaded file does not have the expected digest.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrNetworkMismatch is returned when a genesis or a node belongs to
+	// another chain than expected.
+	ErrNetworkMismatch = errors.New("network mismatch")
+	// ErrDoubleSignRisk is returned by start validator when a migrated
+	// validator key could sign heights its old host may have signed.
+	ErrDoubleSignRisk = errors.New("double sign risk")
+)
+
+// StepError is returned by NodeOperate when a step of an operation fails.
+type StepError struct {
+	Step  string
+	Cause error
+	// Retryable reports whether running the operation again may succeed
+	// without changes, e.g. after a network error.
+	Retryable bool
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("failed to execute step [%s]: %v", e.Step, e.Cause)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Cause
+}
+
+// httpStatusError is returned for unexpected HTTP responses.
+type httpStatusError struct {
+	URL    string
+	Status string
+	Code   int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("bad status from %s: %s", e.URL, e.Status)
+}
+
+func (e *httpStatusError) Retryable() bool {
+	return e.Code >= 500 || e.Code == 429
+}
+
+// isRetryable reports whether err is a transient failure: a timeout, a
+// refused or reset connection, or a server side HTTP error. Other network
+// errors, such as an unknown host or an unsupported URL scheme, and
+// certificate and TLS failures, which come from the configuration, e.g. a
+// wrong --ca-bundle or client certificate, are not retryable.
+func isRetryable(err error) bool {
+	var r interface{ Retryable() bool }
+	if errors.As(err, &r) {
+		return r.Retryable()
+	}
+	if isTLSConfigError(err) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		// A connection closed in the middle of a response.
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isTLSConfigError reports whether err is a certificate verification or TLS
+// handshake failure.
+func isTLSConfigError(err error) bool {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		invalid          x509.CertificateInvalidError
+		hostname         x509.HostnameError
+		systemRoots      x509.SystemRootsError
+		constraint       x509.ConstraintViolationError
+		insecureAlg      x509.InsecureAlgorithmError
+		recordHeader     tls.RecordHeaderError
+		opErr            *net.OpError
+	)
+	switch {
+	case errors.As(err, &unknownAuthority),
+		errors.As(err, &invalid),
+		errors.As(err, &hostname),
+		errors.As(err, &systemRoots),
+		errors.As(err, &constraint),
+		errors.As(err, &insecureAlg),
+		errors.As(err, &recordHeader):
+		return true
+	case errors.As(err, &opErr):
+		// TLS alerts, such as a rejected client certificate.
+		return opErr.Op == "remote error" || opErr.Op == "local error"
+	}
+	return false
+}
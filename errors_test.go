@@ -0,0 +1,54 @@
+package glitterboot
+
+import (
+	"context"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// timeoutError is a net.Error of a timed out operation.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	get := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://example.com/glitter", Err: err}
+	}
+	dial := func(err error) error {
+		return get(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)})
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"timeout", get(timeoutError{}), true},
+		{"deadline", errors.Wrap(context.DeadlineExceeded, "step"), true},
+		{"connection refused", dial(syscall.ECONNREFUSED), true},
+		{"connection reset", dial(syscall.ECONNRESET), true},
+		{"truncated body", errors.Wrap(io.ErrUnexpectedEOF, "download"), true},
+		{"server error", &httpStatusError{Code: 503}, true},
+		{"not found", &httpStatusError{Code: 404}, false},
+		{"unknown host", get(&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true}}), false},
+		{"unsupported scheme", get(errors.New(`unsupported protocol scheme "ftp"`)), false},
+		{"unknown authority", get(x509.UnknownAuthorityError{}), false},
+		{"no route", dial(syscall.EHOSTUNREACH), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Fatalf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package glitterboot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// executor performs every change node operations make to the host, so that a
+// dry run can print the plan instead of acting on it.
+type executor interface {
+	Systemctl(ctx context.Context, args ...string) error
+	DownloadFile(ctx context.Context, filepath string, url string) error
+	WriteFile(filename string, data []byte, perm os.FileMode) error
+	CopyFile(d CopyFileDesc) error
+	MkdirAll(path string, perm os.FileMode) error
+	Rename(oldpath, newpath string) error
+	RemoveAll(path string) error
+	Chmod(path string, mode os.FileMode) error
+	Chown(path string, user, group string, recursion bool) error
+}
+
+var (
+	_ executor = hostExecutor{}
+	_ executor = new(planExecutor)
+)
+
+type hostExecutor struct {
+	dl *downloader
+}
+
+func (hostExecutor) Systemctl(ctx context.Context, args ...string) error {
+	return systemctl(ctx, args...)
+}
+
+func (e hostExecutor) DownloadFile(ctx context.Context, filepath string, url string) error {
+	return e.dl.Download(ctx, filepath, url)
+}
+
+func (hostExecutor) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(filename, data, perm)
+}
+
+func (hostExecutor) CopyFile(d CopyFileDesc) error {
+	return copyFile(d)
+}
+
+func (hostExecutor) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (hostExecutor) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (hostExecutor) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (hostExecutor) Chmod(path string, mode os.FileMode) error {
+	return os.Chmod(path, mode)
+}
+
+func (hostExecutor) Chown(path string, user, group string, recursion bool) error {
+	return chown(path, user, group, recursion)
+}
+
+// planExecutor prints the actions instead of performing them. Files written
+// during the plan are kept in memory so that copying them over installed
+// files can be shown as a diff.
+type planExecutor struct {
+	files  map[string][]byte
+	report func(e Event)
+}
+
+func newPlanExecutor(report func(e Event)) *planExecutor {
+	return &planExecutor{files: map[string][]byte{}, report: report}
+}
+
+func (e *planExecutor) plan(format string, a ...interface{}) {
+	e.report(Event{Type: EventPlan, Message: fmt.Sprintf(format, a...)})
+}
+
+func (e *planExecutor) Systemctl(ctx context.Context, args ...string) error {
+	e.plan("systemctl %s", strings.Join(args, " "))
+	return nil
+}
+
+func (e *planExecutor) DownloadFile(ctx context.Context, filepath string, url string) error {
+	e.plan("download %s -> %s", url, filepath)
+	return nil
+}
+
+func (e *planExecutor) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	e.plan("write %s (%d bytes, mode %s)", filename, len(data), perm)
+	e.files[filename] = data
+	return nil
+}
+
+func (e *planExecutor) CopyFile(d CopyFileDesc) error {
+	ev := Event{Type: EventPlan, Message: fmt.Sprintf("copy %s -> %s", d.Src, d.Dest)}
+	defer func() { e.report(ev) }()
+
+	src, ok := e.files[d.Src]
+	if !ok {
+		var err error
+		src, err = ioutil.ReadFile(d.Src)
+		if err != nil {
+			return nil
+		}
+	}
+	e.files[d.Dest] = src
+
+	installed, err := ioutil.ReadFile(d.Dest)
+	switch {
+	case os.IsNotExist(err):
+		ev.Detail = "new file " + d.Dest
+	case err != nil:
+	case bytes.Equal(installed, src):
+		ev.Detail = d.Dest + " is unchanged"
+	case isBinary(installed) || isBinary(src):
+		ev.Detail = "binary file " + d.Dest + " differs"
+	default:
+		ev.Detail = lineDiff(d.Dest, installed, src)
+	}
+	return nil
+}
+
+func (e *planExecutor) MkdirAll(path string, perm os.FileMode) error {
+	e.plan("mkdir -p %s", path)
+	return nil
+}
+
+func (e *planExecutor) Rename(oldpath, newpath string) error {
+	e.plan("move %s -> %s", oldpath, newpath)
+	return nil
+}
+
+func (e *planExecutor) RemoveAll(path string) error {
+	e.plan("remove %s", path)
+	return nil
+}
+
+func (e *planExecutor) Chmod(path string, mode os.FileMode) error {
+	e.plan("chmod %o %s", mode, path)
+	return nil
+}
+
+func (e *planExecutor) Chown(path string, user, group string, recursion bool) error {
+	if recursion {
+		e.plan("chown -R %s:%s %s", user, group, path)
+		return nil
+	}
+	e.plan("chown %s:%s %s", user, group, path)
+	return nil
+}
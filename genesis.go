@@ -0,0 +1,267 @@
+package glitterboot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+// prepareGenesis sets where the genesis comes from and what it must match.
+func prepareGenesis(ctx *setupNodeCtx, args NodeOpsArgs) error {
+	ctx.GenesisFile = args.GenesisFile
+	ctx.GenesisURL = args.GenesisURL
+	ctx.GenesisSHA256 = strings.ToLower(args.GenesisSHA256)
+	ctx.ChainID = args.ChainID
+
+	if ctx.GenesisFile != "" && ctx.GenesisURL != "" {
+		return errors.Wrap(ErrInvalidArgument, "genesis file and genesis url are mutually exclusive")
+	}
+	if args.Bundle != "" && args.Type == OpsInit && (ctx.GenesisFile != "" || ctx.GenesisURL != "") {
+		return errors.Wrap(ErrInvalidArgument, "the genesis of a bundle can not be replaced by a genesis file or url")
+	}
+	if ctx.GenesisSHA256 != "" && !validSHA256(ctx.GenesisSHA256) {
+		return errors.Wrapf(ErrInvalidArgument, "genesis sha256 %q: must be 64 hex characters", ctx.GenesisSHA256)
+	}
+	return nil
+}
+
+// fetchGenesis returns the genesis from the bundle, the genesis file or url,
+// or else the cluster RPC, once it matches the expected sha256 and chain id.
+func fetchGenesis(ctx *setupNodeCtx) ([]byte, *tmtypes.GenesisDoc, error) {
+	var (
+		b   []byte
+		src string
+		err error
+	)
+	switch {
+	case ctx.bundle != nil:
+		src = "bundle " + bundleGenesisFile
+		b, err = ioutil.ReadFile(ctx.bundle.path(bundleGenesisFile))
+	case ctx.GenesisFile != "":
+		src = ctx.GenesisFile
+		b, err = ioutil.ReadFile(ctx.GenesisFile)
+		if err != nil {
+			err = errors.Wrap(ErrInvalidArgument, err.Error())
+		}
+	case ctx.GenesisURL != "":
+		src = ctx.GenesisURL
+		b, err = httpGet(ctx.Context, ctx.httpClient, ctx.GenesisURL)
+	default:
+		src = "cluster RPC"
+		b, err = fetchClusterGenesis(ctx)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if ctx.GenesisSHA256 != "" {
+		sum := sha256.Sum256(b)
+		if actual := hex.EncodeToString(sum[:]); actual != ctx.GenesisSHA256 {
+			return nil, nil, errors.Wrapf(ErrChecksumMismatch, "genesis from %s: expected sha256 %s, got %s", src, ctx.GenesisSHA256, actual)
+		}
+	}
+	doc, err := tmtypes.GenesisDocFromJSON(b)
+	if err != nil {
+		return nil, nil, errors.Errorf("genesis from %s: %v", src, err)
+	}
+	if ctx.ChainID != "" && doc.ChainID != ctx.ChainID {
+		return nil, nil, errors.Wrapf(ErrNetworkMismatch, "genesis from %s is for chain %q, expected %q", src, doc.ChainID, ctx.ChainID)
+	}
+	return b, doc, nil
+}
+
+// rpcGenesis is the genesis served by one cluster RPC endpoint.
+type rpcGenesis struct {
+	endpoint string
+	genesis  []byte
+	sha256   string
+	err      error
+}
+
+// fetchClusterGenesis queries the genesis from every cluster RPC endpoint
+// at once and fails unless all the healthy ones serve the same genesis.
+// Unhealthy endpoints are reported and ignored, the first healthy one
+// becomes the cluster RPC of the operation.
+func fetchClusterGenesis(ctx *setupNodeCtx) ([]byte, error) {
+	results := make([]rpcGenesis, len(ctx.ClusterRPCs))
+	var wg sync.WaitGroup
+	for i, endpoint := range ctx.ClusterRPCs {
+		wg.Add(1)
+		go func(r *rpcGenesis, endpoint string) {
+			defer wg.Done()
+			r.endpoint = endpoint
+			r.genesis, r.err = fetchRPCGenesis(ctx.Context, endpoint, ctx.rpcClient)
+			if r.err == nil {
+				sum := sha256.Sum256(r.genesis)
+				r.sha256 = hex.EncodeToString(sum[:])
+			}
+		}(&results[i], endpoint)
+	}
+	wg.Wait()
+
+	var (
+		genesis  []byte
+		lastErr  error
+		byDigest = map[string][]string{}
+	)
+	for _, r := range results {
+		if r.err != nil {
+			ctx.warn("cluster RPC %s is unhealthy: %v", r.endpoint, r.err)
+			lastErr = r.err
+			continue
+		}
+		if genesis == nil {
+			genesis = r.genesis
+			ctx.assert(ctx.useClusterRPC(r.endpoint))
+		}
+		byDigest[r.sha256] = append(byDigest[r.sha256], r.endpoint)
+	}
+
+	switch len(byDigest) {
+	case 0:
+		return nil, errors.Wrap(lastErr, "no cluster RPC is healthy")
+	case 1:
+		return genesis, nil
+	}
+	var diverged []string
+	for digest, endpoints := range byDigest {
+		diverged = append(diverged, fmt.Sprintf("sha256 %s: %s", digest, strings.Join(endpoints, ", ")))
+	}
+	sort.Strings(diverged)
+	return nil, errors.Wrapf(ErrNetworkMismatch, "cluster RPC endpoints serve different genesis files:\n  %s", strings.Join(diverged, "\n  "))
+}
+
+// fetchRPCGenesis returns the genesis of endpoint once it passed a health
+// check. The genesis is the result of the genesis call as served, with the
+// indentation of the response removed: the genesis.json of a chain in
+// compact form has the same sha256 from the cluster RPC as from a file or
+// url.
+func fetchRPCGenesis(ctx context.Context, endpoint string, client *http.Client) ([]byte, error) {
+	c, err := newTMClient(endpoint, client)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRPCHealth(ctx, c); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint,
+		strings.NewReader(`{"jsonrpc":"2.0","id":0,"method":"genesis","params":{}}`))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &httpStatusError{URL: endpoint, Status: resp.Status, Code: resp.StatusCode}
+	}
+	var res struct {
+		Result *struct {
+			Genesis json.RawMessage `json:"genesis"`
+		} `json:"result"`
+		Error *struct {
+			Message string `json:"message"`
+			Data    string `json:"data"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, errors.Errorf("genesis from %s: %v", endpoint, err)
+	}
+	if res.Error != nil {
+		return nil, errors.Errorf("genesis from %s: %s %s", endpoint, res.Error.Message, res.Error.Data)
+	}
+	if res.Result == nil || len(res.Result.Genesis) == 0 {
+		return nil, errors.Errorf("genesis from %s: empty result", endpoint)
+	}
+	var b bytes.Buffer
+	if err := json.Compact(&b, res.Result.Genesis); err != nil {
+		return nil, errors.Errorf("genesis from %s: %v", endpoint, err)
+	}
+	return b.Bytes(), nil
+}
+
+// stepDownloadGenesis writes the verified genesis and records its chain id,
+// refusing to switch an initialized node to another network.
+func stepDownloadGenesis(ctx *setupNodeCtx) error {
+	b, doc, err := fetchGenesis(ctx)
+	if err != nil {
+		return err
+	}
+	stored, err := ctx.store.Get(keyChainID)
+	ctx.assert(err)
+	if stored != "" && stored != doc.ChainID {
+		return errors.Wrapf(ErrNetworkMismatch, "node is initialized for chain %q, the genesis is for %q", stored, doc.ChainID)
+	}
+
+	err = ctx.exec.WriteFile(pathJoin(ctx.WorkDir, "genesis.json"), b, 0644)
+	ctx.assert(err)
+	ctx.genesis = doc
+	sum := sha256.Sum256(b)
+	err = ctx.store.Set(keyGenesisSHA256, hex.EncodeToString(sum[:]))
+	ctx.assert(err)
+	return ctx.store.Set(keyChainID, doc.ChainID)
+}
+
+// genesisDoc returns the genesis of the node being initialized, read from
+// the work dir when it was downloaded by an earlier run.
+func (c *setupNodeCtx) genesisDoc() (*tmtypes.GenesisDoc, error) {
+	if c.genesis != nil {
+		return c.genesis, nil
+	}
+	path := pathJoin(c.WorkDir, "genesis.json")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := tmtypes.GenesisDocFromJSON(b)
+	if err != nil {
+		return nil, errors.Errorf("%s: %v", path, err)
+	}
+	c.genesis = doc
+	return doc, nil
+}
+
+// checkNetwork refuses to operate on a node of another network than the
+// one it was initialized for, or than expected when a chain id is given.
+func (c *setupNodeCtx) checkNetwork(expected string) error {
+	stored, err := c.store.Get(keyChainID)
+	c.assert(err)
+	if stored == "" {
+		// Initialized before the chain id was recorded.
+		return nil
+	}
+	if expected != "" && expected != stored {
+		return errors.Wrapf(ErrNetworkMismatch, "node is initialized for chain %q, not %q", stored, expected)
+	}
+
+	installed := pathJoin(installdir, "tendermint/config", "genesis.json")
+	b, err := ioutil.ReadFile(installed)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	c.assert(err)
+	doc, err := tmtypes.GenesisDocFromJSON(b)
+	if err != nil {
+		return errors.Errorf("%s: %v", installed, err)
+	}
+	if doc.ChainID != stored {
+		return errors.Wrapf(ErrNetworkMismatch, "%s is for chain %q, the node was initialized for %q", installed, doc.ChainID, stored)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package glitterboot
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	tmjson "github.com/tendermint/tendermint/libs/json"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+)
+
+// newTestRPC returns a tendermint JSON-RPC server answering each method
+// with the result given by methods. Responses are indented like the ones of
+// tendermint.
+func newTestRPC(t *testing.T, methods map[string]func() json.RawMessage) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res := struct {
+			JSONRPC string          `json:"jsonrpc"`
+			ID      json.RawMessage `json:"id"`
+			Result  json.RawMessage `json:"result,omitempty"`
+			Error   interface{}     `json:"error,omitempty"`
+		}{JSONRPC: "2.0", ID: req.ID}
+		if f, ok := methods[req.Method]; ok {
+			res.Result = f()
+		} else {
+			res.Error = map[string]interface{}{"code": -32601, "message": "Method not found", "data": req.Method}
+		}
+		b, err := json.MarshalIndent(res, "", "  ")
+		if err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// tmResult returns v encoded as a tendermint RPC result.
+func tmResult(t *testing.T, v interface{}) func() json.RawMessage {
+	return func() json.RawMessage {
+		b, err := tmjson.Marshal(v)
+		if err != nil {
+			t.Error(err)
+		}
+		return b
+	}
+}
+
+// healthyRPC returns the methods of a healthy node at height.
+func healthyRPC(t *testing.T, height int64) map[string]func() json.RawMessage {
+	status := &ctypes.ResultStatus{}
+	status.SyncInfo.LatestBlockHeight = height
+	return map[string]func() json.RawMessage{
+		"health": tmResult(t, &ctypes.ResultHealth{}),
+		"status": tmResult(t, status),
+	}
+}
+
+func TestFetchGenesisDigest(t *testing.T) {
+	doc := testGenesis(newTestValidators(1))
+	doc.GenesisTime = time.Now().UTC().Truncate(time.Second)
+	doc.AppState = json.RawMessage(`{"accounts":[{"address":"glitter1","coins":"100"}]}`)
+	published, err := tmjson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256Hex(published)
+
+	file := filepath.Join(t.TempDir(), "genesis.json")
+	if err := ioutil.WriteFile(file, published, 0644); err != nil {
+		t.Fatal(err)
+	}
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(published)
+	}))
+	defer web.Close()
+	methods := healthyRPC(t, 100)
+	methods["genesis"] = func() json.RawMessage {
+		return json.RawMessage(`{"genesis":` + string(published) + `}`)
+	}
+	rpc := newTestRPC(t, methods)
+
+	sources := []struct {
+		name string
+		set  func(ctx *setupNodeCtx)
+	}{
+		{"file", func(ctx *setupNodeCtx) { ctx.GenesisFile = file }},
+		{"url", func(ctx *setupNodeCtx) { ctx.GenesisURL = web.URL + "/genesis.json" }},
+		{"cluster rpc", func(ctx *setupNodeCtx) { ctx.ClusterRPCs = []string{rpc.URL} }},
+	}
+	for _, src := range sources {
+		t.Run(src.name, func(t *testing.T) {
+			for _, tt := range []struct {
+				sha256 string
+				err    error
+			}{
+				{digest, nil},
+				{sha256Hex([]byte("another genesis")), ErrChecksumMismatch},
+			} {
+				var got []byte
+				err := runTestStep(func(ctx *setupNodeCtx) error {
+					src.set(ctx)
+					ctx.GenesisSHA256 = tt.sha256
+					b, _, err := fetchGenesis(ctx)
+					got = b
+					return err
+				})
+				if tt.err != nil {
+					if !errors.Is(err, tt.err) {
+						t.Fatalf("got %v, want %v", err, tt.err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if sha256Hex(got) != digest {
+					t.Fatalf("got genesis %s, want %s", got, published)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package glitterboot
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	tmjson "github.com/tendermint/tendermint/libs/json"
+	"github.com/tendermint/tendermint/privval"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	keyEnvelopeVersion = 1
+	keyEnvelopeKDF     = "scrypt"
+	keyEnvelopeCipher  = "xsalsa20-poly1305"
+
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	// scryptMaxN bounds the work a key file can ask for.
+	scryptMaxN = 1 << 20
+)
+
+// keyEnvelope is an exported validator key encrypted with secretbox under a
+// key derived from a passphrase with scrypt.
+type keyEnvelope struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Cipher     string `json:"cipher"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// exportedValidator is the plaintext of a key envelope. State is the last
+// sign state of the validator when it was exported, ChainID the chain the
+// node was initialized for.
+type exportedValidator struct {
+	ChainID string          `json:"chain_id,omitempty"`
+	Key     json.RawMessage `json:"priv_validator_key"`
+	State   json.RawMessage `json:"priv_validator_state,omitempty"`
+}
+
+// sealKey encrypts plain under passphrase.
+func sealKey(plain, passphrase []byte) ([]byte, error) {
+	e := keyEnvelope{
+		Version: keyEnvelopeVersion,
+		KDF:     keyEnvelopeKDF,
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Salt:    make([]byte, 32),
+		Cipher:  keyEnvelopeCipher,
+		Nonce:   make([]byte, 24),
+	}
+	if _, err := rand.Read(e.Salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(e.Nonce); err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key(passphrase, e.Salt, e.N, e.R, e.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	var k [32]byte
+	var nonce [24]byte
+	copy(k[:], key)
+	copy(nonce[:], e.Nonce)
+	e.Ciphertext = secretbox.Seal(nil, plain, &nonce, &k)
+	return json.MarshalIndent(e, "", "  ")
+}
+
+// openKey decrypts a key envelope with passphrase.
+func openKey(data, passphrase []byte) ([]byte, error) {
+	var e keyEnvelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, errors.Wrapf(ErrInvalidArgument, "not an encrypted key file: %v", err)
+	}
+	if e.Version != keyEnvelopeVersion || e.KDF != keyEnvelopeKDF || e.Cipher != keyEnvelopeCipher {
+		return nil, errors.Wrapf(ErrInvalidArgument, "unsupported key file version %d, kdf %q, cipher %q", e.Version, e.KDF, e.Cipher)
+	}
+	if e.N <= 1 || e.N > scryptMaxN || e.N&(e.N-1) != 0 || e.R <= 0 || e.P <= 0 || e.R*e.P >= 1<<30 || len(e.Nonce) != 24 {
+		return nil, errors.Wrap(ErrInvalidArgument, "key file has invalid parameters")
+	}
+	key, err := scrypt.Key(passphrase, e.Salt, e.N, e.R, e.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	var k [32]byte
+	var nonce [24]byte
+	copy(k[:], key)
+	copy(nonce[:], e.Nonce)
+	plain, ok := secretbox.Open(nil, e.Ciphertext, &nonce, &k)
+	if !ok {
+		return nil, errors.Wrap(ErrInvalidArgument, "wrong passphrase or corrupted key file")
+	}
+	return plain, nil
+}
+
+// loadExportedValidator decrypts the key file written by keys export and
+// checks that its private key matches its address and public key.
+func loadExportedValidator(file string, passphrase func(confirm bool) ([]byte, error)) (*exportedValidator, *privval.FilePVKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, errors.Wrap(ErrInvalidArgument, err.Error())
+	}
+	if passphrase == nil {
+		return nil, nil, errors.Wrap(ErrInvalidArgument, "a passphrase is required to decrypt "+file)
+	}
+	pass, err := passphrase(false)
+	if err != nil {
+		return nil, nil, err
+	}
+	plain, err := openKey(data, pass)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, file)
+	}
+
+	var v exportedValidator
+	if err := json.Unmarshal(plain, &v); err != nil {
+		return nil, nil, errors.Wrapf(ErrInvalidArgument, "%s: %v", file, err)
+	}
+	var key privval.FilePVKey
+	if err := tmjson.Unmarshal(v.Key, &key); err != nil {
+		return nil, nil, errors.Wrapf(ErrInvalidArgument, "%s: priv_validator_key: %v", file, err)
+	}
+	if key.PrivKey == nil || !key.PrivKey.PubKey().Equals(key.PubKey) || !bytes.Equal(key.PubKey.Address(), key.Address) {
+		return nil, nil, errors.Wrapf(ErrInvalidArgument, "%s: the private key does not match its address", file)
+	}
+	return &v, &key, nil
+}
+
+// checkChain fails when the key was exported from a node of another chain
+// than chainID.
+func (v *exportedValidator) checkChain(chainID string) error {
+	if v.ChainID != "" && chainID != "" && v.ChainID != chainID {
+		return errors.Wrapf(ErrNetworkMismatch, "the validator key was exported for chain %q, the node is on %q", v.ChainID, chainID)
+	}
+	return nil
+}
+
+// keysExport writes the validator key and sign state of the node to
+// args.KeyFile, encrypted with the passphrase.
+func keysExport(ctx context.Context, args NodeOpsArgs) error {
+	p := newNodeOpsPipe(ctx, args)
+	p.Do("Export validator key", func(ctx *setupNodeCtx) error {
+		if err := checkKeyExportArgs(args); err != nil {
+			return err
+		}
+		err := ctx.openStore(false)
+		if err != nil {
+			return err
+		}
+		done, err := ctx.store.Get(keyInitDone)
+		ctx.assert(err)
+		if done != "true" {
+			return errors.Wrap(ErrNotInitialized, "please init node first before export keys")
+		}
+		return exportValidatorKey(ctx, args, false)
+	})
+	return p.Error()
+}
+
+func checkKeyExportArgs(args NodeOpsArgs) error {
+	if !args.Encrypt {
+		return errors.Wrap(ErrInvalidArgument, "validator keys are only exported encrypted, use --encrypt")
+	}
+	if args.KeyFile == "" {
+		return errors.Wrap(ErrInvalidArgument, "keys export: output file is required")
+	}
+	if args.Passphrase == nil {
+		return errors.Wrap(ErrInvalidArgument, "keys export: a passphrase is required")
+	}
+	return nil
+}
+
+// exportValidatorKey writes the installed validator key and sign state to
+// args.KeyFile, failing without a sign state when requireState is set.
+func exportValidatorKey(ctx *setupNodeCtx, args NodeOpsArgs, requireState bool) error {
+	var (
+		v   exportedValidator
+		err error
+	)
+	v.ChainID, err = ctx.store.Get(keyChainID)
+	ctx.assert(err)
+	v.Key, err = ioutil.ReadFile(pathJoin(installdir, "tendermint/config", "priv_validator_key.json"))
+	ctx.assert(err)
+	statePath := pathJoin(installdir, "tendermint/data", "priv_validator_state.json")
+	v.State, err = ioutil.ReadFile(statePath)
+	switch {
+	case os.IsNotExist(err) && requireState:
+		return errors.Errorf("%s does not exist, the last signed height is unknown", statePath)
+	case os.IsNotExist(err):
+		v.State = nil
+	default:
+		ctx.assert(err)
+	}
+	plain, err := json.Marshal(v)
+	ctx.assert(err)
+
+	pass, err := args.Passphrase(true)
+	if err != nil {
+		return err
+	}
+	if len(pass) == 0 {
+		return errors.Wrap(ErrInvalidArgument, "keys export: the passphrase is empty")
+	}
+	sealed, err := sealKey(plain, pass)
+	ctx.assert(err)
+	if ctx.dryRun {
+		ctx.report(Event{Type: EventPlan, Message: "write the encrypted validator key to " + args.KeyFile})
+		return nil
+	}
+	tmp := args.KeyFile + ".tmp"
+	err = ioutil.WriteFile(tmp, sealed, 0600)
+	ctx.assert(err)
+	defer os.Remove(tmp)
+	return os.Rename(tmp, args.KeyFile)
+}
+
+// stepImportValidatorFile installs the validator key imported during
+// Prepare, with the sign state it was exported with. As after
+// migrate-validator import, start validator then checks that sign state
+// against the height of the chain, see stepHoldImportedValidator.
+func stepImportValidatorFile(ctx *setupNodeCtx) error {
+	v, key := ctx.importedValidator, ctx.importedKey
+	chainID, err := ctx.store.Get(keyChainID)
+	ctx.assert(err)
+	if err := v.checkChain(chainID); err != nil {
+		return err
+	}
+	jsbz, err := tmjson.Marshal(key)
+	ctx.assert(err)
+	err = ctx.exec.WriteFile(pathJoin(ctx.WorkDir, "priv_validator_key.json"), jsbz, 0600)
+	ctx.assert(err)
+
+	state := []byte(v.State)
+	signHeight := ""
+	if len(state) == 0 {
+		ctx.warn("the imported key has no sign state, make sure the validator no longer runs anywhere else")
+		state, err = tmjson.Marshal(&privval.FilePVLastSignState{})
+		ctx.assert(err)
+	} else {
+		var last privval.FilePVLastSignState
+		if err := tmjson.Unmarshal(state, &last); err != nil {
+			return errors.Wrapf(ErrInvalidArgument, "imported priv_validator_state: %v", err)
+		}
+		signHeight = strconv.FormatInt(last.Height, 10)
+	}
+	err = ctx.exec.WriteFile(pathJoin(ctx.WorkDir, "priv_validator_state.json"), state, 0600)
+	ctx.assert(err)
+
+	ctx.ValidatorAddress = key.Address.String()
+	ctx.ValidatorPubKey = key.PubKey
+	err = ctx.store.Set(keyPubKey, base64.StdEncoding.EncodeToString(key.PubKey.Bytes()))
+	ctx.assert(err)
+	err = ctx.store.Set(keyValidatorStage, "")
+	ctx.assert(err)
+	err = ctx.store.Set(keyMigratedSignHeight, signHeight)
+	ctx.assert(err)
+	return ctx.store.Set(keyPubKeyAddress, key.Address.String())
+}
+
+// stepHoldImportedValidator puts a key imported by init with its sign state
+// on hold once the files are installed, as migrate-validator import does.
+func stepHoldImportedValidator(ctx *setupNodeCtx) error {
+	signHeight, err := ctx.store.Get(keyMigratedSignHeight)
+	ctx.assert(err)
+	if ctx.importedKey == nil || signHeight == "" {
+		return nil
+	}
+	return holdValidator(ctx)
+}
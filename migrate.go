@@ -0,0 +1,338 @@
+package glitterboot
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	tmjson "github.com/tendermint/tendermint/libs/json"
+	"github.com/tendermint/tendermint/privval"
+)
+
+// localRPCProbeTimeout bounds the check that no tendermint answers locally
+// once the unit is stopped.
+const localRPCProbeTimeout = 3 * time.Second
+
+// migrateValidatorExport stops tendermint for good on the old host of a
+// validator, checks that it no longer runs, and writes its key and last sign
+// state to args.KeyFile, encrypted with the passphrase.
+func migrateValidatorExport(ctx context.Context, args NodeOpsArgs) error {
+	p := newNodeOpsPipe(ctx, args)
+	p.
+		Do("Check", func(ctx *setupNodeCtx) error {
+			ctx.WorkDir = bootdir
+			ctx.StoreDir = storedir
+
+			if err := checkKeyExportArgs(args); err != nil {
+				return err
+			}
+			err := ctx.openStore(false)
+			if err != nil {
+				return err
+			}
+			done, err := ctx.store.Get(keyInitDone)
+			ctx.assert(err)
+			if done != "true" {
+				return errors.Wrap(ErrNotInitialized, "please init node first before migrate the validator")
+			}
+			return ctx.checkNetwork(args.ChainID)
+		}).
+		Do("Stop tendermint", func(ctx *setupNodeCtx) error {
+			if err := ctx.stopUnit("tendermint"); err != nil {
+				return err
+			}
+			// Keep a reboot from starting the old validator again.
+			ctx.onUndo("enable tendermint", func() error {
+				return ctx.exec.Systemctl(context.Background(), "enable", "tendermint")
+			})
+			return ctx.exec.Systemctl(ctx.Context, "disable", "tendermint")
+		}).
+		Do("Verify tendermint is stopped", func(ctx *setupNodeCtx) error {
+			if ctx.dryRun {
+				ctx.report(Event{Type: EventPlan, Message: "check that tendermint is inactive and nothing answers on http://127.0.0.1:26657"})
+				return nil
+			}
+			if unitActive("tendermint") {
+				return errors.New("tendermint is still active after systemctl stop")
+			}
+			return checkLocalRPCDown(ctx.Context, "http://127.0.0.1:26657")
+		}).
+		Do("Export validator key", func(ctx *setupNodeCtx) error {
+			return exportValidatorKey(ctx, args, true)
+		}).
+		Commit()
+	return p.Error()
+}
+
+// checkLocalRPCDown fails when a tendermint still answers on endpoint, e.g.
+// one started outside of systemd.
+func checkLocalRPCDown(ctx context.Context, endpoint string) error {
+	c, err := NewTMClient(endpoint)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, localRPCProbeTimeout)
+	defer cancel()
+	if _, err := c.Health(ctx); err == nil {
+		return errors.Errorf("tendermint still answers on %s, stop it before the validator key is exported", endpoint)
+	}
+	return nil
+}
+
+// migrateValidatorImport installs the validator key and sign state written
+// by migrate-validator export on an initialized node. Tendermint is left
+// stopped and on hold; start validator then checks the imported sign state
+// against the height of the chain.
+func migrateValidatorImport(ctx context.Context, args NodeOpsArgs) error {
+	var (
+		v     *exportedValidator
+		key   *privval.FilePVKey
+		state *privval.FilePVLastSignState
+	)
+	p := newNodeOpsPipe(ctx, args)
+	p.
+		Do("Check", func(ctx *setupNodeCtx) error {
+			ctx.WorkDir = bootdir
+			ctx.StoreDir = storedir
+
+			err := ctx.openStore(false)
+			if err != nil {
+				return err
+			}
+			done, err := ctx.store.Get(keyInitDone)
+			ctx.assert(err)
+			if done != "true" {
+				return errors.Wrap(ErrNotInitialized, "please init node first before import the validator")
+			}
+			if err := ctx.checkNetwork(args.ChainID); err != nil {
+				return err
+			}
+
+			v, key, err = loadExportedValidator(args.ImportValidatorKey, args.Passphrase)
+			if err != nil {
+				return err
+			}
+			chainID, err := ctx.store.Get(keyChainID)
+			ctx.assert(err)
+			if err := v.checkChain(chainID); err != nil {
+				return err
+			}
+			if len(v.State) == 0 {
+				return errors.Wrapf(ErrInvalidArgument, "%s has no sign state, export it with migrate-validator export", args.ImportValidatorKey)
+			}
+			state = new(privval.FilePVLastSignState)
+			if err := tmjson.Unmarshal(v.State, state); err != nil {
+				return errors.Wrapf(ErrInvalidArgument, "%s: priv_validator_state: %v", args.ImportValidatorKey, err)
+			}
+			return nil
+		}).
+		Do("Stop tendermint", func(ctx *setupNodeCtx) error {
+			return ctx.stopUnit("tendermint")
+		}).
+		Do("Install validator key", func(ctx *setupNodeCtx) error {
+			keyFile := pathJoin(installdir, "tendermint/config", "priv_validator_key.json")
+			stateFile := pathJoin(installdir, "tendermint/data", "priv_validator_state.json")
+			keyJSON, err := tmjson.Marshal(key)
+			ctx.assert(err)
+
+			stateJSON := []byte(v.State)
+			installed, err := readValidatorKey(keyFile)
+			ctx.assert(err)
+			if installed != nil && bytes.Equal(installed.Address, key.Address) {
+				// The same key again, never go back to an older sign state.
+				current, err := readSignState(stateFile)
+				ctx.assert(err)
+				if current != nil && signedLater(current, state) {
+					ctx.warn("keeping %s, it signed height %d, the imported state only %d", stateFile, current.Height, state.Height)
+					stateJSON, err = tmjson.Marshal(current)
+					ctx.assert(err)
+					state = current
+				}
+			}
+
+			for _, f := range []struct {
+				staged, dest string
+				data         []byte
+			}{
+				{pathJoin(ctx.WorkDir, "priv_validator_key.json"), keyFile, keyJSON},
+				{pathJoin(ctx.WorkDir, "priv_validator_state.json"), stateFile, stateJSON},
+			} {
+				for _, path := range []string{f.staged, f.dest} {
+					err := ctx.setAside(path)
+					ctx.assert(err)
+					err = ctx.exec.MkdirAll(filepath.Dir(path), 0755)
+					ctx.assert(err)
+					err = ctx.exec.WriteFile(path, f.data, 0600)
+					ctx.assert(err)
+				}
+				err := ctx.exec.Chown(f.dest, glitterUser, glitterGroup, false)
+				ctx.assert(err)
+			}
+
+			err = ctx.store.Set(keyPubKey, base64.StdEncoding.EncodeToString(key.PubKey.Bytes()))
+			ctx.assert(err)
+			err = ctx.store.Set(keyPubKeyAddress, key.Address.String())
+			ctx.assert(err)
+			err = ctx.store.Set(keyValidatorStage, "")
+			ctx.assert(err)
+			return ctx.store.Set(keyMigratedSignHeight, strconv.FormatInt(state.Height, 10))
+		}).
+		Do("Hold validator", holdValidator).
+		Commit()
+	return p.Error()
+}
+
+// holdValidator keeps the key imported by migrate-validator import from
+// signing until stepCheckDoubleSign passed: the installed tendermint config
+// is switched to full node mode and the unit is disabled, so that neither a
+// reboot nor a plain systemctl start runs the validator.
+func holdValidator(ctx *setupNodeCtx) error {
+	tmConfigPath := pathJoin(installdir, "tendermint/config", "config.toml")
+	err := ctx.setAside(tmConfigPath)
+	ctx.assert(err)
+	err = ctx.exec.CopyFile(CopyFileDesc{pathJoin(bootdir, "tendermint-full.config.toml"), tmConfigPath})
+	ctx.assert(err)
+
+	// A second import finds the unit disabled by the first one.
+	if unitEnabled("tendermint") {
+		err = ctx.store.Set(keyMigratedUnitEnabled, "true")
+		ctx.assert(err)
+		ctx.onUndo("enable tendermint", func() error {
+			return ctx.exec.Systemctl(context.Background(), "enable", "tendermint")
+		})
+	}
+	return ctx.exec.Systemctl(ctx.Context, "disable", "tendermint")
+}
+
+// stepCheckDoubleSign refuses to start a validator whose key was imported by
+// migrate-validator import unless its sign state has reached the height of
+// the chain: a lower state means blocks were produced since the export, and
+// the old host may have signed them.
+func stepCheckDoubleSign(ctx *setupNodeCtx) error {
+	migrated, err := ctx.store.Get(keyMigratedSignHeight)
+	ctx.assert(err)
+	if migrated == "" {
+		return nil
+	}
+	signed, err := strconv.ParseInt(migrated, 10, 64)
+	ctx.assert(err)
+
+	var (
+		chainHeight int64
+		observed    string
+	)
+	for _, endpoint := range ctx.ClusterRPCs {
+		c, err := newTMClient(endpoint, ctx.rpcClient)
+		if err == nil {
+			err = checkRPCHealth(ctx.Context, c)
+		}
+		if err != nil {
+			ctx.warn("cluster RPC %s is unhealthy: %v", endpoint, err)
+			continue
+		}
+		status, err := c.Status(ctx.Context)
+		if err != nil {
+			ctx.warn("cluster RPC %s: %v", endpoint, err)
+			continue
+		}
+		if h := status.SyncInfo.LatestBlockHeight; h > chainHeight {
+			chainHeight, observed = h, endpoint
+		}
+	}
+	if observed == "" {
+		return errors.New("double sign check: no cluster RPC is healthy, the chain height is unknown")
+	}
+	if signed < chainHeight {
+		return errors.Wrapf(ErrDoubleSignRisk, "the imported validator last signed height %d, %s is at height %d",
+			signed, observed, chainHeight)
+	}
+
+	// Tendermint was left stopped by the import, so the validator set is
+	// looked up on the cluster instead of waiting on the local node.
+	address, err := ctx.store.Get(keyPubKeyAddress)
+	ctx.assert(err)
+	c, err := newTMClient(observed, ctx.rpcClient)
+	ctx.assert(err)
+	inSet, err := inValidatorSet(ctx.Context, c, address)
+	if err != nil {
+		return errors.Wrapf(err, "double sign check: %s", observed)
+	}
+	if inSet {
+		err = ctx.store.Set(keyValidatorStage, "ok")
+		ctx.assert(err)
+	} else {
+		ctx.warn("%s is not in the validator set of %s", address, observed)
+	}
+
+	// Release the hold of the import.
+	enabled, err := ctx.store.Get(keyMigratedUnitEnabled)
+	ctx.assert(err)
+	if enabled == "true" {
+		ctx.onUndo("disable tendermint", func() error {
+			return ctx.exec.Systemctl(context.Background(), "disable", "tendermint")
+		})
+		if err := ctx.exec.Systemctl(ctx.Context, "enable", "tendermint"); err != nil {
+			return err
+		}
+	}
+	ctx.onCommit(func() error {
+		if err := ctx.store.Set(keyMigratedUnitEnabled, ""); err != nil {
+			return err
+		}
+		return ctx.store.Set(keyMigratedSignHeight, "")
+	})
+	return nil
+}
+
+// inValidatorSet reports whether address is in the latest validator set.
+func inValidatorSet(ctx context.Context, c *TendermintClient, address string) (bool, error) {
+	perPage, seen := 100, 0
+	for page := 1; ; page++ {
+		res, err := c.Validators(ctx, nil, &page, &perPage)
+		if err != nil {
+			return false, err
+		}
+		for _, v := range res.Validators {
+			if v.Address.String() == address {
+				return true, nil
+			}
+		}
+		seen += len(res.Validators)
+		if len(res.Validators) == 0 || seen >= res.Total {
+			return false, nil
+		}
+	}
+}
+
+// signedLater reports whether a is a later sign state than b.
+func signedLater(a, b *privval.FilePVLastSignState) bool {
+	if a.Height != b.Height {
+		return a.Height > b.Height
+	}
+	if a.Round != b.Round {
+		return a.Round > b.Round
+	}
+	return a.Step > b.Step
+}
+
+// readValidatorKey returns the validator key in file, nil if there is none.
+func readValidatorKey(file string) (*privval.FilePVKey, error) {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var key privval.FilePVKey
+	if err := tmjson.Unmarshal(b, &key); err != nil {
+		return nil, errors.Errorf("%s: %v", file, err)
+	}
+	return &key, nil
+}
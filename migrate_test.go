@@ -0,0 +1,254 @@
+package glitterboot
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	tmjson "github.com/tendermint/tendermint/libs/json"
+	"github.com/tendermint/tendermint/privval"
+	ctypes "github.com/tendermint/tendermint/rpc/core/types"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+// useLoggingSystemctl replaces the fake systemctl of useTestInstall with one
+// reporting the tendermint unit enabled, and returns the calls made so far.
+func useLoggingSystemctl(t *testing.T) func() []string {
+	bin := t.TempDir()
+	log := filepath.Join(bin, "calls")
+	script := `#!/bin/sh
+echo "$@" >> ` + log + `
+case "$1" in
+is-active) echo inactive; exit 3;;
+is-enabled) echo enabled;;
+esac
+exit 0
+`
+	if err := ioutil.WriteFile(filepath.Join(bin, "systemctl"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return func() []string {
+		b, _ := ioutil.ReadFile(log)
+		return strings.Split(strings.TrimSpace(string(b)), "\n")
+	}
+}
+
+func called(calls []string, call string) bool {
+	for _, c := range calls {
+		if c == call {
+			return true
+		}
+	}
+	return false
+}
+
+// newChainRPC returns a cluster RPC at height with key in its validator set.
+func newChainRPC(t *testing.T, height int64, key privval.FilePVKey) string {
+	methods := healthyRPC(t, height)
+	methods["validators"] = tmResult(t, &ctypes.ResultValidators{
+		BlockHeight: height,
+		Validators:  []*tmtypes.Validator{tmtypes.NewValidator(key.PubKey, 10)},
+		Count:       1,
+		Total:       1,
+	})
+	return newTestRPC(t, methods).URL
+}
+
+// installTestNode writes the installed tendermint config in validator mode,
+// the staged full node config and an initialized store.
+func installTestNode(t *testing.T) store {
+	writeTree(t, installdir, map[string]string{"tendermint/config/config.toml": "validator config"})
+	writeTree(t, bootdir, map[string]string{"tendermint-full.config.toml": "full config"})
+	s, err := newFileStore(storedir, true)
+	if err == nil {
+		err = s.Set(keyInitDone, "true")
+	}
+	if err == nil {
+		err = s.Set(keyChainID, testChainID)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func installedTMConfig(t *testing.T) string {
+	b, err := ioutil.ReadFile(filepath.Join(installdir, "tendermint/config/config.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func storeValue(t *testing.T, key string) string {
+	s, err := newFileStore(storedir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// checkDoubleSign runs the double sign check of start validator against
+// the cluster RPC endpoint.
+func checkDoubleSign(endpoint string) error {
+	p := newNodeOpsPipe(context.Background(), NodeOpsArgs{Reporter: NewTextReporter(ioutil.Discard)})
+	p.
+		Do("Prepare", func(ctx *setupNodeCtx) error {
+			ctx.WorkDir = bootdir
+			ctx.StoreDir = storedir
+			ctx.ClusterRPCs = []string{endpoint}
+			return ctx.openStore(false)
+		}).
+		Do("Check double sign", stepCheckDoubleSign).
+		Commit()
+	return p.Error()
+}
+
+func TestInitImportedValidatorHold(t *testing.T) {
+	tests := []struct {
+		name        string
+		chainHeight int64
+		ok          bool
+	}{
+		{"sign state behind the chain", 101, false},
+		{"sign state at the chain height", 100, true},
+		{"sign state above the chain height", 99, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestInstall(t)
+			calls := useLoggingSystemctl(t)
+			installTestNode(t)
+
+			pv := privval.GenFilePV("", "")
+			keyJSON, err := tmjson.Marshal(pv.Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stateJSON, err := tmjson.Marshal(&privval.FilePVLastSignState{Height: 100, Round: 0, Step: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := &exportedValidator{ChainID: testChainID, Key: json.RawMessage(keyJSON), State: json.RawMessage(stateJSON)}
+
+			// The import steps of init.
+			p := newNodeOpsPipe(context.Background(), NodeOpsArgs{Reporter: NewTextReporter(ioutil.Discard)})
+			p.
+				Do("Prepare", func(ctx *setupNodeCtx) error {
+					ctx.WorkDir = bootdir
+					ctx.StoreDir = storedir
+					ctx.importedValidator, ctx.importedKey = v, &pv.Key
+					return ctx.openStore(false)
+				}).
+				Do("Generate validator key files", stepGenerateValidatorFile).
+				Do("Hold imported validator", stepHoldImportedValidator).
+				Commit()
+			if err := p.Error(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := storeValue(t, keyMigratedSignHeight); got != "100" {
+				t.Fatalf("got sign height %q, want 100", got)
+			}
+			if got := installedTMConfig(t); got != "full config" {
+				t.Fatalf("got tendermint config %q, want the full node one", got)
+			}
+			if !called(calls(), "disable tendermint") {
+				t.Fatalf("tendermint unit not disabled: %v", calls())
+			}
+
+			err = checkDoubleSign(newChainRPC(t, tt.chainHeight, pv.Key))
+			if !tt.ok {
+				if !errors.Is(err, ErrDoubleSignRisk) {
+					t.Fatalf("got %v, want %v", err, ErrDoubleSignRisk)
+				}
+				if got := storeValue(t, keyMigratedSignHeight); got != "100" {
+					t.Fatalf("hold released, sign height %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, k := range []string{keyMigratedSignHeight, keyMigratedUnitEnabled} {
+				if got := storeValue(t, k); got != "" {
+					t.Fatalf("hold not released, %s is %q", k, got)
+				}
+			}
+			if got := storeValue(t, keyValidatorStage); got != "ok" {
+				t.Fatalf("got validator stage %q, want ok", got)
+			}
+			if !called(calls(), "enable tendermint") {
+				t.Fatalf("tendermint unit not enabled again: %v", calls())
+			}
+		})
+	}
+}
+
+func TestRestoreKeepsValidatorHold(t *testing.T) {
+	useTestInstall(t)
+	// As installed, so that restore accepts the store.
+	bootdir = filepath.Join(installdir, "glitter-boot")
+	storedir = filepath.Join(bootdir, "store.json")
+	calls := useLoggingSystemctl(t)
+	s := installTestNode(t)
+
+	pv := privval.GenFilePV("", "")
+	keyJSON, err := tmjson.Marshal(pv.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, installdir, map[string]string{"tendermint/config/priv_validator_key.json": string(keyJSON)})
+	backup := filepath.Join(t.TempDir(), "backup.tar.gz")
+	err = NodeOperate(context.Background(), NodeOpsArgs{
+		Type:       OpsBackup,
+		BackupFile: backup,
+		Reporter:   NewTextReporter(ioutil.Discard),
+	})
+	if err != nil {
+		t.Fatalf("backup: %v", err)
+	}
+
+	// The same key imported after the backup, not yet checked.
+	for k, v := range map[string]string{
+		keyMigratedSignHeight:  strconv.Itoa(100),
+		keyMigratedUnitEnabled: "true",
+		keyPubKeyAddress:       pv.Key.Address.String(),
+	} {
+		if err := s.Set(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = NodeOperate(context.Background(), NodeOpsArgs{
+		Type:       OpsRestore,
+		BackupFile: backup,
+		Force:      true,
+		Reporter:   NewTextReporter(ioutil.Discard),
+	})
+	if err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if got := storeValue(t, keyMigratedSignHeight); got != "100" {
+		t.Fatalf("got sign height %q after restore, want 100", got)
+	}
+	if got := installedTMConfig(t); got != "full config" {
+		t.Fatalf("got tendermint config %q after restore, want the full node one", got)
+	}
+	if !called(calls(), "disable tendermint") {
+		t.Fatalf("tendermint unit not disabled: %v", calls())
+	}
+	if err := checkDoubleSign(newChainRPC(t, 101, pv.Key)); !errors.Is(err, ErrDoubleSignRisk) {
+		t.Fatalf("got %v, want %v", err, ErrDoubleSignRisk)
+	}
+}
@@ -1,36 +1,147 @@
 package glitterboot
 
 import (
-	"fmt"
+	"context"
+	"encoding/hex"
 	"net"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/tendermint/tendermint/p2p"
 )
 
+// NodeAddr is a tendermint peer address, id@host:port. parseNodeAddr
+// normalises it: the ID is lowercase, IPv6 hosts are unbracketed and host
+// names are lowercase without a trailing dot.
 type NodeAddr struct {
+	// Address is the node ID, the hex address of the node key.
 	Address string
 	Host    string
 	Port    string
 }
 
 func (n *NodeAddr) String() string {
-	return fmt.Sprintf("%s@%s:%s", n.Address, n.Host, n.Port)
+	return n.Address + "@" + net.JoinHostPort(n.Host, n.Port)
+}
+
+// seedRPCURL returns the tendermint RPC endpoint of a seed.
+func seedRPCURL(n *NodeAddr) string {
+	return "http://" + net.JoinHostPort(n.Host, "26657")
+}
+
+// hostResolver looks up the IP addresses of a host name.
+type hostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// peerResolver resolves the host names of peers, tests can replace it.
+var peerResolver hostResolver = net.DefaultResolver
+
+// resolve returns the IP addresses of the host of n, or the host itself
+// when it is an IP address.
+func (n *NodeAddr) resolve(ctx context.Context, r hostResolver) ([]string, error) {
+	if net.ParseIP(n.Host) != nil {
+		return []string{n.Host}, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+	return r.LookupHost(ctx, n.Host)
 }
 
 func parseNodeAddr(idHostPort string) (*NodeAddr, error) {
-	v := strings.Split(idHostPort, "@")
+	v := strings.Split(strings.TrimSpace(idHostPort), "@")
 	if len(v) != 2 {
-		return nil, errors.Errorf("invalid node IdHostPort: %s", idHostPort)
+		return nil, errors.Wrapf(ErrInvalidArgument, "node %q: must be id@host:port", idHostPort)
 	}
-	n := &NodeAddr{
-		Address: v[0],
+	id := strings.ToLower(v[0])
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 2*p2p.IDByteLength {
+		return nil, errors.Wrapf(ErrInvalidArgument, "node %q: node ID must be %d hex characters", idHostPort, 2*p2p.IDByteLength)
 	}
 	host, port, err := net.SplitHostPort(v[1])
 	if err != nil {
-		return nil, errors.Errorf("invalid node IdHostPort: %s err=%v", idHostPort, err)
+		return nil, errors.Wrapf(ErrInvalidArgument, "node %q: %v", idHostPort, err)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	} else {
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		if !validHostname(host) {
+			return nil, errors.Wrapf(ErrInvalidArgument, "node %q: %q is not an IP address or host name", idHostPort, host)
+		}
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || p == 0 {
+		return nil, errors.Wrapf(ErrInvalidArgument, "node %q: port must be a number from 1 to 65535", idHostPort)
+	}
+	return &NodeAddr{Address: id, Host: host, Port: strconv.FormatUint(p, 10)}, nil
+}
+
+// peerEntry is one entry of a comma separated peer list.
+type peerEntry struct {
+	input string
+	addr  *NodeAddr
+	// duplicate is set when the same peer is listed before.
+	duplicate bool
+	err       error
+}
+
+// splitNodeAddrs parses every entry of a comma separated peer list,
+// skipping empty ones. A node ID listed again with another address is an
+// error, since tendermint keeps a single address per node ID.
+func splitNodeAddrs(s string) []peerEntry {
+	var entries []peerEntry
+	byID := map[string]*NodeAddr{}
+	for _, in := range strings.Split(s, ",") {
+		in = strings.TrimSpace(in)
+		if in == "" {
+			continue
+		}
+		e := peerEntry{input: in}
+		e.addr, e.err = parseNodeAddr(in)
+		if e.err == nil {
+			if prev, ok := byID[e.addr.Address]; !ok {
+				byID[e.addr.Address] = e.addr
+			} else if prev.String() == e.addr.String() {
+				e.duplicate = true
+			} else {
+				e.err = errors.Wrapf(ErrInvalidArgument, "node %q: node ID is already listed as %s", in, prev)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// parseNodeAddrs parses a comma separated peer list, keeping repeated
+// peers once.
+func parseNodeAddrs(s string) ([]*NodeAddr, error) {
+	var addrs []*NodeAddr
+	for _, e := range splitNodeAddrs(s) {
+		if e.err != nil {
+			return nil, e.err
+		}
+		if !e.duplicate {
+			addrs = append(addrs, e.addr)
+		}
+	}
+	return addrs, nil
+}
+
+// validHostname reports whether host is a syntactically valid DNS name.
+func validHostname(host string) bool {
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return false
+			}
+		}
 	}
-	n.Host = host
-	n.Port = port
-	return n, nil
+	return true
 }
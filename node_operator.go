@@ -4,8 +4,9 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
-	"io/ioutil"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,11 +14,16 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/tendermint/tendermint/crypto"
+	"github.com/tendermint/tendermint/crypto/ed25519"
 	tmjson "github.com/tendermint/tendermint/libs/json"
 	"github.com/tendermint/tendermint/p2p"
 	"github.com/tendermint/tendermint/privval"
+	tmtypes "github.com/tendermint/tendermint/types"
 )
 
+// NodeOpsArgs are the arguments of a node operation. Fields tagged json:"-"
+// are not saved in a plan, apply takes them from its own command line
+// through setRuntime.
 type NodeOpsArgs struct {
 	Type                NodeOperateType
 	Seeds               string
@@ -25,6 +31,118 @@ type NodeOpsArgs struct {
 	IndexMode           string
 	GlitterBinaryURL    string
 	TendermintBinaryURL string
+
+	// GlitterBinarySHA256 and TendermintBinarySHA256 are the expected hex
+	// digests of the downloaded binaries.
+	GlitterBinarySHA256    string
+	TendermintBinarySHA256 string
+	// FetchSHA256Sums looks up missing digests in the SHA256SUMS file next
+	// to the binary URL.
+	FetchSHA256Sums bool
+	// ReleaseManifest is a signed release manifest, URL or path, that the
+	// binary URLs and digests are taken from.
+	ReleaseManifest string
+	// GlitterVersion and TendermintVersion pin the binaries to a release,
+	// resolved through ReleaseManifest or else ReleaseIndex.
+	GlitterVersion    string
+	TendermintVersion string
+	// ReleaseIndex is an unsigned release index, URL or path.
+	ReleaseIndex string
+	// GlitterBinPathInArchive and TendermintBinPathInArchive select the
+	// binary in a downloaded tar.gz or zip archive. By default the entry
+	// named glitter or tendermint is used.
+	GlitterBinPathInArchive    string
+	TendermintBinPathInArchive string
+
+	// Proxy is the HTTP proxy URL for downloads and the cluster RPC,
+	// HTTPS_PROXY and HTTP_PROXY are used when empty.
+	Proxy string `json:"-"`
+	// CABundle is a PEM file of CAs trusted in addition to the system roots.
+	CABundle string `json:"-"`
+	// ClientCert and ClientKey are PEM files authenticating glitter-boot to
+	// the cluster RPC endpoints.
+	ClientCert string `json:"-"`
+	ClientKey  string `json:"-"`
+
+	// ClusterRPC are tendermint RPC endpoints of the cluster, used instead
+	// of the RPC of the seeds on port 26657.
+	ClusterRPC []string
+
+	// GenesisFile and GenesisURL replace the genesis of the cluster RPC.
+	GenesisFile string
+	GenesisURL  string
+	// GenesisSHA256 is the expected hex digest of the genesis file, of the
+	// genesis url response, or of the compacted genesis of the cluster RPC.
+	GenesisSHA256 string
+	// ChainID is the expected chain id of the genesis, and of the node for
+	// the commands run after init.
+	ChainID string
+
+	// Bundle is the offline bundle init installs from instead of downloading,
+	// or the file bundle create writes.
+	Bundle string
+
+	// SnapshotURL is the tar.gz, tar.lz4 or tar archive restore-snapshot
+	// streams, SnapshotSHA256 its expected digest.
+	SnapshotURL    string
+	SnapshotSHA256 string
+
+	// BackupFile is the archive backup writes and restore reads. BackupData
+	// includes the data directories, Force lets restore replace an
+	// initialized node.
+	BackupFile string
+	BackupData bool
+	Force      bool
+
+	// ImportValidatorKey is a key file written by keys export that init
+	// installs instead of generating a validator key.
+	ImportValidatorKey string
+	// KeyFile is the file keys export writes, encrypted when Encrypt is set.
+	KeyFile string
+	Encrypt bool
+	// Passphrase returns the passphrase of a key file, confirm asks for it
+	// twice when it is chosen.
+	Passphrase func(confirm bool) ([]byte, error) `json:"-"`
+
+	// SkipPreflight skips dialing the seeds before init.
+	SkipPreflight bool
+
+	// StateSync makes the new node state sync from a trust height taken
+	// from the cluster RPC instead of replaying the chain from genesis.
+	// StateSyncTrustPeriod is the trust_period of its light client, the
+	// trust height itself is verified from genesis whatever its age.
+	StateSync            bool
+	StateSyncTrustPeriod time.Duration
+
+	// Resume skips init steps already recorded as finished with the same inputs.
+	Resume bool
+	// FromStep reruns init starting at the named step.
+	FromStep string
+	// OnlyStep reruns the single named init step.
+	OnlyStep string
+
+	// CacheFile is the file cache add puts into the artifact cache.
+	CacheFile string `json:"-"`
+	// PruneAll makes cache prune also remove the binaries of the installed
+	// node, PruneOlderThan spares entries added more recently.
+	PruneAll       bool          `json:"-"`
+	PruneOlderThan time.Duration `json:"-"`
+
+	// PlanFile is where plan writes the plan and where apply reads it from.
+	PlanFile string `json:"-"`
+
+	// DryRun prints what the operation would do without changing the host.
+	DryRun bool `json:"-"`
+	// Reporter receives the progress of the operation, text on stdout if nil.
+	Reporter Reporter `json:"-"`
+	// StepTimeout bounds the duration of every step, zero means no limit.
+	StepTimeout time.Duration `json:"-"`
+	// DownloadMirrors are tried in order when a download from its original
+	// URL fails. A mirror replaces the scheme and host of the URL.
+	DownloadMirrors []string `json:"-"`
+	// DownloadRetries is the number of extra attempts for transient download
+	// failures, negative means the default.
+	DownloadRetries int `json:"-"`
 }
 
 var (
@@ -43,6 +161,21 @@ const (
 	keyPubKeyAddress  = "pub_key_address"
 	keyInitDone       = "init_done"
 	keyValidatorStage = "validator_stage"
+	keyStepDone       = "step_done:"
+	// keyMigratedSignHeight is the sign state height of a validator key
+	// imported by migrate-validator import, until start validator passes
+	// the double sign check.
+	keyMigratedSignHeight = "migrated_sign_height"
+	// keyMigratedUnitEnabled is "true" when the tendermint unit was enabled
+	// before migrate-validator import disabled it.
+	keyMigratedUnitEnabled = "migrated_unit_enabled"
+
+	keyGlitterBinSHA256    = "glitter_bin_sha256"
+	keyTendermintBinSHA256 = "tendermint_bin_sha256"
+	keyGlitterVersion      = "glitter_version"
+	keyTendermintVersion   = "tendermint_version"
+	keyChainID             = "chain_id"
+	keyGenesisSHA256       = "genesis_sha256"
 )
 
 type NodeOperateType int
@@ -53,155 +186,298 @@ const (
 	OpsStartValidator
 	OpsStopNode
 	OpsShowNodeInfo
+	OpsPlan
+	OpsApply
+	OpsCacheList
+	OpsCacheAdd
+	OpsCachePrune
+	OpsBundleCreate
+	OpsPeersValidate
+	OpsRestoreSnapshot
+	OpsBackup
+	OpsRestore
+	OpsKeysExport
+	OpsMigrateExport
+	OpsMigrateImport
 )
 
-func NodeOperate(ctx context.Context, args NodeOpsArgs) {
+// NodeOperate runs the operation selected by args.Type. Failed steps are
+// reported as *StepError.
+func NodeOperate(ctx context.Context, args NodeOpsArgs) error {
 	switch args.Type {
 	case OpsInit:
-		initNode(ctx, args)
+		return initNode(ctx, args)
 	case OpsStartFullNode:
-		startFullNode(ctx, args)
+		return startFullNode(ctx, args)
 	case OpsStartValidator:
-		startValidator(ctx, args)
+		return startValidator(ctx, args)
 	case OpsStopNode:
-		stopNode(ctx, args)
+		return stopNode(ctx, args)
 	case OpsShowNodeInfo:
-		showNodeInfo(ctx, args)
+		return showNodeInfo(ctx, args)
+	case OpsPlan:
+		return planNode(ctx, args)
+	case OpsApply:
+		return applyPlan(ctx, args)
+	case OpsCacheList:
+		return cacheListOps(ctx, args)
+	case OpsCacheAdd:
+		return cacheAddOps(ctx, args)
+	case OpsCachePrune:
+		return cachePruneOps(ctx, args)
+	case OpsBundleCreate:
+		return bundleCreate(ctx, args)
+	case OpsPeersValidate:
+		return peersValidateOps(ctx, args)
+	case OpsRestoreSnapshot:
+		return restoreSnapshot(ctx, args)
+	case OpsBackup:
+		return backupNode(ctx, args)
+	case OpsRestore:
+		return restoreNode(ctx, args)
+	case OpsKeysExport:
+		return keysExport(ctx, args)
+	case OpsMigrateExport:
+		return migrateValidatorExport(ctx, args)
+	case OpsMigrateImport:
+		return migrateValidatorImport(ctx, args)
 	}
+	return errors.Wrapf(ErrInvalidArgument, "unknown operation %d", args.Type)
 }
 
-func initNode(ctx context.Context, args NodeOpsArgs) {
-	p := &nodeOpsPipe{}
-	p.
-		Do("Prepare", func(ctx *setupNodeCtx) error {
-			ctx.WorkDir = bootdir
-			ctx.StoreDir = storedir
-			ctx.Moniker = args.Moniker
-			ctx.IndexMode = args.IndexMode
-			ctx.SeedsStr = args.Seeds
-			ctx.GlitterBinaryURL = args.GlitterBinaryURL
-			ctx.TendermintBinaryURL = args.TendermintBinaryURL
+func initNode(ctx context.Context, args NodeOpsArgs) error {
+	return runInitNode(ctx, args, nil)
+}
 
-			var err error
-			err = checkUserGroup(glitterUser, glitterGroup)
+// runInitNode runs init. verify, if set, runs right after Prepare and can
+// refuse to continue with the resolved context.
+func runInitNode(ctx context.Context, args NodeOpsArgs, verify func(ctx *setupNodeCtx, cp *stepCheckpoint) error) error {
+	cp, err := newStepCheckpoint(args, initNodeSteps)
+	if err != nil {
+		return err
+	}
 
-			if err != nil {
-				return errors.Errorf("failed to got glitter user/group: %v", err)
-			}
+	p := newNodeOpsPipe(ctx, args)
+	p.Do("Prepare", prepareInitNode(args))
+	if !args.SkipPreflight {
+		p.Do("Preflight", stepPreflight)
+	}
+	if verify != nil {
+		p.Do("Verify plan", func(ctx *setupNodeCtx) error {
+			return verify(ctx, cp)
+		})
+	}
+	p.WithCheckpoint(cp)
+	for _, s := range initNodeSteps {
+		p.Do(s.Name, s.Run)
+	}
+	p.Commit()
+
+	return p.Error()
+}
 
-			ctx.store, err = newFileStore(storedir, true)
+// initNodeSteps are the checkpointed steps of init, run in order after Prepare.
+var initNodeSteps = []nodeOpsStep{
+	{"Download tendermint", stepDownloadTendermint},
+	{"Download glitter", stepDownloadGlitter},
+	{"Download genesis file", stepDownloadGenesis},
+	{"Render glitter config", stepRenderGlitterConfig},
+	{"Render tendermint config", stepRenderTendermintConfig},
+	{"Render systemctl config", stepRenderSystemctlConfig},
+	{"Generate nodekey files", stepGenerateNodeKeyFile},
+	{"Generate validator key files", stepGenerateValidatorFile},
+	{"Verify binaries", stepVerifyBinaries},
+	{"Reset and copy files", stepResetCopyFile},
+	{"Hold imported validator", stepHoldImportedValidator},
+	{"Save config", stepSaveConfig},
+}
+
+func prepareInitNode(args NodeOpsArgs) func(ctx *setupNodeCtx) error {
+	return func(ctx *setupNodeCtx) error {
+		ctx.WorkDir = bootdir
+		ctx.StoreDir = storedir
+		ctx.Moniker = args.Moniker
+		ctx.IndexMode = args.IndexMode
+		ctx.StateSync = args.StateSync
+		ctx.TrustPeriod = args.StateSyncTrustPeriod
+		if ctx.TrustPeriod == 0 {
+			ctx.TrustPeriod = defaultTrustPeriod
+		}
+		if ctx.TrustPeriod < 0 {
+			return errors.Wrap(ErrInvalidArgument, "state sync trust period must be positive")
+		}
+
+		err := prepareBinaries(ctx, args)
+		if err != nil {
+			return err
+		}
+		err = prepareGenesis(ctx, args)
+		if err != nil {
+			return err
+		}
+		if args.ImportValidatorKey != "" {
+			ctx.importedValidator, ctx.importedKey, err = loadExportedValidator(args.ImportValidatorKey, args.Passphrase)
 			if err != nil {
 				return err
 			}
-			done, err := ctx.store.Get(keyInitDone)
-			ctx.assert(err)
-			if done == "true" {
-				return errors.New("Full node has already setup,please remove ~/.glitter-boot dir then redo current command if you want to reset it")
-			}
+		}
 
-			for _, s := range strings.Split(ctx.SeedsStr, ",") {
-				s = strings.TrimSpace(s)
-				a, err := parseNodeAddr(s)
-				if err != nil {
-					return err
-				}
-				ctx.Seeds = append(ctx.Seeds, a)
-			}
-			if len(ctx.Seeds) == 0 {
-				return errors.New("invalid argument seeds: at least provide one seed")
-			}
-			selectedSeed := ctx.Seeds[0]
-			ctx.OldClusterTendermintRPCURL = "http://" + net.JoinHostPort(selectedSeed.Host, "26657")
-			ctx.OldClusterGlitterURL = "http://" + net.JoinHostPort(selectedSeed.Host, "26659")
-			ctx.LocalTendermintRPCURL = "http://127.0.0.1:26657"
-			os.MkdirAll(bootdir, 0755)
-			c, err := NewTMClient(ctx.OldClusterTendermintRPCURL)
-			ctx.assert(err)
+		err = checkUserGroup(glitterUser, glitterGroup)
 
-			cLocal, err := NewTMClient(ctx.LocalTendermintRPCURL)
-			ctx.assert(err)
+		if err != nil {
+			return errors.Errorf("failed to got glitter user/group: %v", err)
+		}
 
-			ctx.tmClusterClient = c
-			ctx.tmLocalClient = cLocal
-			return nil
-		}).
-		Do("Download tendermint", stepDownloadTendermint).
-		Do("Download glitter", stepDownloadGlitter).
-		Do("Download genesis file", stepDownloadGenesis).
-		Do("Render glitter config", stepRenderGlitterConfig).
-		Do("Render tendermint config", stepRenderTendermintConfig).
-		Do("Render systemctl config", stepRenderSystemctlConfig).
-		Do("Generate nodekey files", stepGenerateNodeKeyFile).
-		Do("Generate validator key files", stepGenerateValidatorFile).
-		Do("Reset and copy files", stepResetCopyFile).
-		Do("Save config", func(ctx *setupNodeCtx) error {
-			err := ctx.store.Set(keySeeds, ctx.SeedsStr)
-			ctx.assert(err)
+		err = ctx.openStore(true)
+		if err != nil {
+			return err
+		}
+		done, err := ctx.store.Get(keyInitDone)
+		ctx.assert(err)
+		if done == "true" && args.FromStep == "" && args.OnlyStep == "" {
+			return errors.Wrap(ErrAlreadyInitialized, "please remove the glitter-boot dir then redo current command if you want to reset it")
+		}
 
-			err = ctx.store.Set(keyMoniker, ctx.Moniker)
-			ctx.assert(err)
+		ctx.exec.MkdirAll(bootdir, 0755)
+		err = prepareSeeds(ctx, args)
+		if err != nil {
+			return err
+		}
 
-			err = ctx.store.Set(keyInitDone, "true")
-			ctx.assert(err)
+		cLocal, err := NewTMClient(ctx.LocalTendermintRPCURL)
+		ctx.assert(err)
+		ctx.tmLocalClient = cLocal
+		return nil
+	}
+}
 
-			return nil
-		})
+// prepareBinaries sets where the binaries come from and their digests, from
+// the arguments, a release manifest or index, or an offline bundle.
+func prepareBinaries(ctx *setupNodeCtx, args NodeOpsArgs) error {
+	ctx.GlitterBinaryURL = args.GlitterBinaryURL
+	ctx.TendermintBinaryURL = args.TendermintBinaryURL
+	ctx.GlitterBinarySHA256 = strings.ToLower(args.GlitterBinarySHA256)
+	ctx.TendermintBinarySHA256 = strings.ToLower(args.TendermintBinarySHA256)
+	ctx.FetchSHA256Sums = args.FetchSHA256Sums
+	ctx.TendermintBinPathInArchive = args.TendermintBinPathInArchive
+	ctx.GlitterBinPathInArchive = args.GlitterBinPathInArchive
+	ctx.GlitterVersion = args.GlitterVersion
+	ctx.TendermintVersion = args.TendermintVersion
+
+	for _, digest := range []string{ctx.GlitterBinarySHA256, ctx.TendermintBinarySHA256} {
+		if digest != "" && !validSHA256(digest) {
+			return errors.Wrapf(ErrInvalidArgument, "sha256 digest %q: must be 64 hex characters", digest)
+		}
+	}
 
-	if err := p.Error(); err != nil {
-		fmt.Println(err)
-		return
+	if args.Bundle != "" && args.Type == OpsInit {
+		return useBundle(ctx, args)
+	}
+	return resolveReleases(ctx, args)
+}
+
+// prepareSeeds parses and normalises the seeds and sets the cluster RPC
+// endpoints, from --cluster-rpc or else one per seed. The first endpoint is
+// preferred until a health check selects another one.
+func prepareSeeds(ctx *setupNodeCtx, args NodeOpsArgs) error {
+	seeds, err := parseNodeAddrs(args.Seeds)
+	if err != nil {
+		return err
+	}
+	if len(seeds) == 0 {
+		return errors.Wrap(ErrInvalidArgument, "seeds: at least provide one seed")
+	}
+	ctx.Seeds = seeds
+	var normalised []string
+	for _, seed := range seeds {
+		normalised = append(normalised, seed.String())
+	}
+	ctx.SeedsStr = strings.Join(normalised, ",")
+
+	ctx.ClusterRPCs = nil
+	for _, endpoint := range args.ClusterRPC {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint == "" {
+			continue
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.Wrapf(ErrInvalidArgument, "cluster rpc %q: must be an http(s) URL like https://rpc.example.com:26657", endpoint)
+		}
+		ctx.ClusterRPCs = append(ctx.ClusterRPCs, endpoint)
 	}
+	if len(ctx.ClusterRPCs) == 0 {
+		for _, seed := range ctx.Seeds {
+			ctx.ClusterRPCs = append(ctx.ClusterRPCs, seedRPCURL(seed))
+		}
+	}
+	ctx.LocalTendermintRPCURL = "http://127.0.0.1:26657"
+	return ctx.useClusterRPC(ctx.ClusterRPCs[0])
+}
 
-	fmt.Println("Init node successfully")
+// useClusterRPC makes endpoint the cluster RPC of the operation.
+func (c *setupNodeCtx) useClusterRPC(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	client, err := newTMClient(endpoint, c.rpcClient)
+	if err != nil {
+		return err
+	}
+	c.OldClusterTendermintRPCURL = endpoint
+	c.OldClusterGlitterURL = u.Scheme + "://" + net.JoinHostPort(u.Hostname(), "26659")
+	c.tmClusterClient = client
+	return nil
 }
 
-func startFullNode(ctx context.Context, args NodeOpsArgs) {
-	p := &nodeOpsPipe{}
+func startFullNode(ctx context.Context, args NodeOpsArgs) error {
+	p := newNodeOpsPipe(ctx, args)
 	p.
 		Do("Check", func(ctx *setupNodeCtx) error {
 			ctx.WorkDir = bootdir
 			var err error
-			ctx.store, err = newFileStore(storedir, true)
+			err = ctx.openStore(true)
 			if err != nil {
 				return err
 			}
 			done, err := ctx.store.Get(keyInitDone)
 			ctx.assert(err)
 			if done != "true" {
-				return errors.New("Please init node first before start the fullnode")
+				return errors.Wrap(ErrNotInitialized, "please init node first before start the fullnode")
 			}
-			return nil
+			return ctx.checkNetwork(args.ChainID)
 		}).
 		Do("Switch to fullnode mode", stepSwitchToFullNode).
 		Do("Restart glitter",
 			func(ctx *setupNodeCtx) error {
-				return systemctl("restart", "glitter")
+				return ctx.exec.Systemctl(ctx.Context, "restart", "glitter")
 			},
-		)
-
-	if err := p.Error(); err != nil {
-		fmt.Println(err)
-		return
-	}
+		).
+		Commit()
 
-	fmt.Println("Start fullnode successfully")
+	return p.Error()
 }
 
-func startValidator(ctx context.Context, args NodeOpsArgs) {
-	p := &nodeOpsPipe{}
+func startValidator(ctx context.Context, args NodeOpsArgs) error {
+	p := newNodeOpsPipe(ctx, args)
 	p.
 		Do("Prepare", func(ctx *setupNodeCtx) error {
 			ctx.WorkDir = bootdir
 			ctx.StoreDir = storedir
 
 			var err error
-			ctx.store, err = newFileStore(storedir, false)
+			err = ctx.openStore(false)
 			ctx.assert(err)
 
 			done, err := ctx.store.Get(keyInitDone)
 			ctx.assert(err)
 			if done != "true" {
-				return errors.New("Please init node first before start the validator")
+				return errors.Wrap(ErrNotInitialized, "please init node first before start the validator")
+			}
+			err = ctx.checkNetwork(args.ChainID)
+			if err != nil {
+				return err
 			}
 
 			ctx.IndexMode = "kv"
@@ -213,93 +489,79 @@ func startValidator(ctx context.Context, args NodeOpsArgs) {
 			ctx.assert(err)
 
 			ctx.tmLocalClient = cLocal
-			return nil
+
+			migrated, err := ctx.store.Get(keyMigratedSignHeight)
+			ctx.assert(err)
+			if migrated == "" {
+				return nil
+			}
+			// The double sign check needs the cluster RPC.
+			args.Seeds, err = ctx.store.Get(keySeeds)
+			ctx.assert(err)
+			return prepareSeeds(ctx, args)
 		}).
+		Do("Check double sign", stepCheckDoubleSign).
 		Do("Waiting to receive a validator change event...", stepWaitForValidator).
 		Do("Switch to validator mode", stepSwitchToValidator).
 		Do("Restart glitter",
 			func(ctx *setupNodeCtx) error {
-				return systemctl("restart", "glitter")
+				return ctx.exec.Systemctl(ctx.Context, "restart", "glitter")
 			},
-		)
-	if err := p.Error(); err != nil {
-		fmt.Println(err)
-		return
-	}
-
-	fmt.Println("Start validator successfully")
+		).
+		Commit()
+	return p.Error()
 }
 
-func stopNode(ctx context.Context, args NodeOpsArgs) {
-	p := &nodeOpsPipe{}
+func stopNode(ctx context.Context, args NodeOpsArgs) error {
+	p := newNodeOpsPipe(ctx, args)
 	p.
 		Do("Check", func(ctx *setupNodeCtx) error {
 			ctx.WorkDir = bootdir
 			ctx.StoreDir = storedir
 
 			var err error
-			ctx.store, err = newFileStore(storedir, false)
+			err = ctx.openStore(false)
 			ctx.assert(err)
 
 			done, err := ctx.store.Get(keyInitDone)
 			ctx.assert(err)
 			if done != "true" {
-				return errors.New("Please init node first before stop")
+				return errors.Wrap(ErrNotInitialized, "please init node first before stop")
 			}
-			return nil
+			return ctx.checkNetwork(args.ChainID)
 		}).
 		Do("Stop tendermint",
 			func(ctx *setupNodeCtx) error {
-				return systemctl("stop", "tendermint")
+				return ctx.exec.Systemctl(ctx.Context, "stop", "tendermint")
 			},
 		).
 		Do("Stop glitter",
 			func(ctx *setupNodeCtx) error {
-				return systemctl("stop", "glitter")
+				return ctx.exec.Systemctl(ctx.Context, "stop", "glitter")
 			},
 		)
-	if err := p.Error(); err != nil {
-		fmt.Println(err)
-		return
-	}
-
-	fmt.Println("Stop node successfully")
+	return p.Error()
 }
 
-func showNodeInfo(ctx context.Context, args NodeOpsArgs) {
-	p := &nodeOpsPipe{}
+func showNodeInfo(ctx context.Context, args NodeOpsArgs) error {
+	p := newNodeOpsPipe(ctx, args)
 	p.
 		Do("Check", func(ctx *setupNodeCtx) error {
 			ctx.WorkDir = bootdir
 			ctx.StoreDir = storedir
 
 			var err error
-			ctx.store, err = newFileStore(storedir, false)
+			err = ctx.openStore(false)
 			ctx.assert(err)
 
 			done, err := ctx.store.Get(keyInitDone)
 			ctx.assert(err)
 			if done != "true" {
-				return errors.New("Please init node first")
+				return errors.Wrap(ErrNotInitialized, "please init node first")
 			}
 			return nil
 		}).
 		Do("Node Info", func(ctx *setupNodeCtx) error {
-			const info = `
-NodeID:		%s
-Moniker:	%s
-
-PubKey:		%s
-Address:	%s
-
-Tendermint Status: %s
-Glitter	   Status: %s
-
-PrivateKeyFile:	~/.glitter-boot/priv_validator_key.json
-GlitterBootDir:	/usr/local/glitter/glitter-boot
-GlitterDir:		/usr/local/glitter/glitter
-
-`
 			get := func(key string) string {
 				value, err := ctx.store.Get(key)
 				ctx.assert(err)
@@ -308,67 +570,197 @@ GlitterDir:		/usr/local/glitter/glitter
 			tmStatus, _ := systemctlOut("is-active", "tendermint")
 			glitterStatus, _ := systemctlOut("is-active", "glitter")
 
-			fmt.Printf(info,
-				get(keyNodeID),
-				get(keyMoniker),
-				get(keyPubKey),
-				get(keyPubKeyAddress),
-				tmStatus,
-				glitterStatus,
-			)
+			ctx.report(Event{Type: EventNodeInfo, NodeInfo: &NodeInfo{
+				NodeID:            get(keyNodeID),
+				Moniker:           get(keyMoniker),
+				PubKey:            get(keyPubKey),
+				Address:           get(keyPubKeyAddress),
+				TendermintStatus:  strings.TrimSpace(tmStatus),
+				GlitterStatus:     strings.TrimSpace(glitterStatus),
+				ChainID:           get(keyChainID),
+				GlitterVersion:    get(keyGlitterVersion),
+				TendermintVersion: get(keyTendermintVersion),
+				PrivateKeyFile:    pathJoin(bootdir, "priv_validator_key.json"),
+				GlitterBootDir:    bootdir,
+				GlitterDir:        pathJoin(installdir, "glitter"),
+			}})
 			return nil
-		},
-		)
-	if err := p.Error(); err != nil {
-		fmt.Printf("%v\n%s\n", err, "Did you initialize the node?")
-		return
+		})
+	return p.Error()
+}
+
+// resolveReleases takes the binary URLs and digests from the signed release
+// manifest, or from the release index for the versions given.
+func resolveReleases(ctx *setupNodeCtx, args NodeOpsArgs) error {
+	var (
+		m   *releaseManifest
+		err error
+	)
+	switch {
+	case args.ReleaseManifest != "":
+		m, err = loadSignedManifest(ctx.Context, ctx.httpClient, args.ReleaseManifest)
+	case args.GlitterVersion == "" && args.TendermintVersion == "":
+		return nil
+	case args.ReleaseIndex == "":
+		return errors.Wrap(ErrInvalidArgument, "a release index or manifest is required to resolve versions")
+	default:
+		m, err = loadReleaseIndex(ctx.Context, ctx.httpClient, args.ReleaseIndex)
 	}
+	ctx.assert(err)
+
+	if args.ReleaseManifest != "" || ctx.TendermintVersion != "" {
+		err = useReleaseArtifact(m, "tendermint", &ctx.TendermintVersion, &ctx.TendermintBinaryURL, &ctx.TendermintBinarySHA256)
+		ctx.assert(err)
+	}
+	if args.ReleaseManifest != "" || ctx.GlitterVersion != "" {
+		err = useReleaseArtifact(m, "glitter", &ctx.GlitterVersion, &ctx.GlitterBinaryURL, &ctx.GlitterBinarySHA256)
+		ctx.assert(err)
+	}
+	return nil
 }
 
 func stepDownloadTendermint(ctx *setupNodeCtx) error {
-	return downloadFile(pathJoin(bootdir, "tendermint"), ctx.TendermintBinaryURL)
+	digest, err := downloadBinary(ctx, pathJoin(ctx.WorkDir, "tendermint"), "tendermint", ctx.TendermintBinaryURL, ctx.TendermintBinarySHA256, ctx.TendermintBinPathInArchive)
+	ctx.assert(err)
+	if digest == "" {
+		return nil
+	}
+	return ctx.store.Set(keyTendermintBinSHA256, digest)
 }
 
 func stepDownloadGlitter(ctx *setupNodeCtx) error {
-	return downloadFile(pathJoin(bootdir, "glitter"), ctx.GlitterBinaryURL)
+	digest, err := downloadBinary(ctx, pathJoin(ctx.WorkDir, "glitter"), "glitter", ctx.GlitterBinaryURL, ctx.GlitterBinarySHA256, ctx.GlitterBinPathInArchive)
+	ctx.assert(err)
+	if digest == "" {
+		return nil
+	}
+	return ctx.store.Set(keyGlitterBinSHA256, digest)
 }
 
-func stepDownloadGenesis(ctx *setupNodeCtx) error {
-	g, err := ctx.tmClusterClient.Genesis(context.TODO())
+// downloadBinary downloads url to dest and, when a digest is given or found
+// in SHA256SUMS, verifies it. It returns the digest of the binary. The binary
+// is taken from the offline bundle as name if there is one, else from the
+// artifact cache when its digest is known or url was downloaded before. An
+// archive is replaced by the binary at inArchive in it, or else the one
+// called name; the digest is the one of the archive.
+func downloadBinary(ctx *setupNodeCtx, dest, name, url, digest, inArchive string) (string, error) {
+	var err error
+	if digest == "" && ctx.FetchSHA256Sums {
+		digest, err = lookupSHA256Sums(ctx.Context, ctx.httpClient, url)
+		ctx.assert(err)
+	}
+
+	// expected is the digest the binary must have, the one recorded for
+	// url in the cache when none is given and the server confirms it did
+	// not change.
+	expected := digest
+	if expected == "" && ctx.bundle == nil {
+		expected = cacheLookupURL(ctx.Context, ctx.httpClient, url)
+	}
+
+	src := ""
+	switch {
+	case ctx.bundle != nil:
+		src = ctx.bundle.path(name)
+	case expected != "" && cacheHas(expected):
+		src = cachePath(expected)
+	}
+	if src != "" {
+		err = ctx.exec.CopyFile(CopyFileDesc{Src: src, Dest: dest})
+	} else {
+		err = ctx.exec.DownloadFile(ctx.Context, dest, url)
+	}
+	ctx.assert(err)
+	cached := expected != "" && src == cachePath(expected)
+
+	if ctx.dryRun {
+		if digest == "" {
+			ctx.warn("no sha256 given for %s, skip checksum verification", url)
+		} else {
+			ctx.report(Event{Type: EventPlan, Message: "verify sha256 of " + dest + " is " + digest})
+		}
+		if inArchive != "" {
+			ctx.report(Event{Type: EventPlan, Message: "extract " + inArchive + " from " + dest})
+		}
+		return digest, nil
+	}
+
+	actual, err := fileSHA256(dest)
+	ctx.assert(err)
+	switch {
+	case expected != "" && actual != expected:
+		os.Remove(dest)
+		os.Remove(dest + validatorSuffix)
+		if cached {
+			ctx.warn("cached %s is corrupted, downloading it again", expected)
+			ctx.assert(os.Remove(cachePath(expected)))
+			return downloadBinary(ctx, dest, name, url, digest, inArchive)
+		}
+		return "", errors.Wrapf(ErrChecksumMismatch, "%s: expected sha256 %s, got %s", url, expected, actual)
+	case digest == "" && cached:
+		ctx.warn("no sha256 given for %s, using the cached copy the server reports unchanged", url)
+	case digest == "":
+		ctx.warn("no sha256 given for %s, skip checksum verification", url)
+	}
+
+	if !cached {
+		if err := cachePut(dest, actual); err != nil {
+			ctx.warn("failed to cache %s: %v", dest, err)
+		}
+	}
+	if ctx.bundle == nil && !cached {
+		if err := cacheRecordURL(url, actual, downloadValidator(dest)); err != nil {
+			ctx.warn("failed to record %s in the cache: %v", url, err)
+		}
+	}
+	digest = actual
+
+	extracted, err := extractBinary(dest, name, inArchive)
 	ctx.assert(err)
-	return jsonToFile(g.Genesis, pathJoin(bootdir, "genesis.json"))
+	if !extracted && inArchive != "" {
+		return "", errors.Wrapf(ErrInvalidArgument, "%s is not an archive, can not extract %s", url, inArchive)
+	}
+	return digest, nil
 }
 
 func stepRenderGlitterConfig(ctx *setupNodeCtx) error {
 	if ctx.IndexMode != "kv" && ctx.IndexMode != "es" {
-		return errors.Errorf("invalid glitter index mode: %s", ctx.IndexMode)
+		return errors.Wrapf(ErrInvalidArgument, "glitter index mode: %s", ctx.IndexMode)
 	}
-	return renderGlitterConfig(pathJoin(bootdir, "glitter.config.toml"),
-		map[string]interface{}{
-			"IndexMode": ctx.IndexMode,
-		})
+	b, err := renderGlitterConfig(string(ctx.template(glitterConfigTemplate)), map[string]interface{}{
+		"IndexMode": ctx.IndexMode,
+	})
+	ctx.assert(err)
+	return ctx.exec.WriteFile(pathJoin(bootdir, "glitter.config.toml"), b, 0644)
 }
 
 func stepRenderTendermintConfig(ctx *setupNodeCtx) error {
-	err := renderTendermintConfig(pathJoin(bootdir, "tendermint-full.config.toml"),
-		map[string]interface{}{
-			"Moniker": ctx.Moniker,
-			"Seeds":   ctx.SeedsStr,
-			"Mode":    "full",
-		})
-	ctx.assert(err)
-	return renderTendermintConfig(pathJoin(bootdir, "tendermint-validator.config.toml"),
-		map[string]interface{}{
+	tpl := string(ctx.template(tendermintConfigTemplate))
+	stateSync, err := stateSyncConfig(ctx)
+	if err != nil {
+		return err
+	}
+	for _, mode := range []string{"full", "validator"} {
+		data := map[string]interface{}{
 			"Moniker": ctx.Moniker,
 			"Seeds":   ctx.SeedsStr,
-			"Mode":    "validator",
-		})
+			"Mode":    mode,
+		}
+		for k, v := range stateSync {
+			data[k] = v
+		}
+		b, err := renderTendermintConfig(tpl, data)
+		ctx.assert(err)
+		err = ctx.exec.WriteFile(pathJoin(bootdir, "tendermint-"+mode+".config.toml"), b, 0644)
+		ctx.assert(err)
+	}
+	return nil
 }
 
 func stepRenderSystemctlConfig(ctx *setupNodeCtx) error {
-	err := ioutil.WriteFile(pathJoin(bootdir, "tendermint.service"), tendermintServiceFile, 0644)
+	err := ctx.exec.WriteFile(pathJoin(bootdir, "tendermint.service"), ctx.template(tendermintServiceTemplate), 0644)
 	ctx.assert(err)
-	return ioutil.WriteFile(pathJoin(bootdir, "glitter.service"), glitterServiceFile, 0644)
+	return ctx.exec.WriteFile(pathJoin(bootdir, "glitter.service"), ctx.template(glitterServiceTemplate), 0644)
 }
 
 func stepGenerateNodeKeyFile(ctx *setupNodeCtx) error {
@@ -376,12 +768,21 @@ func stepGenerateNodeKeyFile(ctx *setupNodeCtx) error {
 
 	_, err := os.Stat(nodeKeyPath)
 	if os.IsExist(err) {
-		fmt.Printf("[WARN] Skip Generate NodeKeyFile: node_key alreay exist")
+		ctx.warn("Skip Generate NodeKeyFile: node_key alreay exist")
 		return nil
 	}
 
-	key, err := p2p.LoadOrGenNodeKey(nodeKeyPath)
-	ctx.assert(err)
+	// Keep an existing node key like p2p.LoadOrGenNodeKey does, but write a
+	// new one through the executor so that a dry run leaves no file behind.
+	key, err := p2p.LoadNodeKey(nodeKeyPath)
+	if err != nil {
+		key = &p2p.NodeKey{PrivKey: ed25519.GenPrivKey()}
+		jsbz, err := tmjson.Marshal(key)
+		ctx.assert(err)
+		err = ctx.exec.WriteFile(nodeKeyPath, jsbz, 0600)
+		ctx.assert(err)
+	}
+
 	err = ctx.store.Set(keyNodeID, string(key.ID()))
 	ctx.assert(err)
 
@@ -389,26 +790,47 @@ func stepGenerateNodeKeyFile(ctx *setupNodeCtx) error {
 }
 
 func stepGenerateValidatorFile(ctx *setupNodeCtx) error {
+	if ctx.importedKey != nil {
+		return stepImportValidatorFile(ctx)
+	}
+	// Only an imported key is held for the double sign check.
+	err := ctx.store.Set(keyMigratedSignHeight, "")
+	ctx.assert(err)
 	validatorKeyPath := pathJoin(ctx.WorkDir, "priv_validator_key.json")
 	validatorStatePath := pathJoin(ctx.WorkDir, "priv_validator_state.json")
 
-	_, err := os.Stat(validatorKeyPath)
-	if os.IsExist(err) {
-		fmt.Printf("[WARN] Skip Generate ValidatorFile: validator_key alreay exist")
-		return nil
+	// Keep a key generated by an earlier run, e.g. one resumed after a
+	// later step failed, instead of replacing the validator identity.
+	existing, err := readValidatorKey(validatorKeyPath)
+	if err == nil && existing != nil {
+		ctx.warn("Skip Generate ValidatorFile: validator_key alreay exist")
+		// Written 0644 by older versions.
+		err := ctx.exec.Chmod(validatorKeyPath, 0600)
+		ctx.assert(err)
+		if _, err := os.Stat(validatorStatePath); os.IsNotExist(err) {
+			stb, err := tmjson.Marshal(&privval.FilePVLastSignState{})
+			ctx.assert(err)
+			err = ctx.exec.WriteFile(validatorStatePath, stb, 0600)
+			ctx.assert(err)
+		}
+		ctx.ValidatorAddress = existing.Address.String()
+		ctx.ValidatorPubKey = existing.PubKey
+		err = ctx.store.Set(keyPubKey, base64.StdEncoding.EncodeToString(existing.PubKey.Bytes()))
+		ctx.assert(err)
+		return ctx.store.Set(keyPubKeyAddress, existing.Address.String())
 	}
 
 	pv := privval.GenFilePV("", "")
 	jsbz, err := tmjson.Marshal(pv.Key)
 	ctx.assert(err)
 
-	err = ioutil.WriteFile(validatorKeyPath, jsbz, 0644)
+	err = ctx.exec.WriteFile(validatorKeyPath, jsbz, 0600)
 	ctx.assert(err)
 
 	stb, err := tmjson.Marshal(pv.LastSignState)
 	ctx.assert(err)
 
-	err = ioutil.WriteFile(validatorStatePath, stb, 0644)
+	err = ctx.exec.WriteFile(validatorStatePath, stb, 0600)
 	ctx.assert(err)
 
 	ctx.ValidatorAddress = pv.GetAddress().String()
@@ -424,30 +846,59 @@ func stepGenerateValidatorFile(ctx *setupNodeCtx) error {
 }
 
 func stepResetCopyFile(ctx *setupNodeCtx) error {
-	systemctl("stop", "tendermint")
-	systemctl("stop", "glitter")
+	ctx.stopUnit("tendermint")
+	ctx.stopUnit("glitter")
+	ctx.onUndo("reload systemd units", func() error {
+		return ctx.exec.Systemctl(context.Background(), "daemon-reload")
+	})
+
+	for _, dir := range []string{pathJoin(installdir, "tendermint"), pathJoin(installdir, "glitter"), "/tmp/kvstore"} {
+		err := ctx.setAside(dir)
+		ctx.assert(err)
+	}
+	ctx.exec.MkdirAll(pathJoin(installdir, "tendermint/config"), 0755)
+	ctx.exec.MkdirAll(pathJoin(installdir, "tendermint/data"), 0755)
+	ctx.exec.MkdirAll(pathJoin(installdir, "glitter"), 0755)
+
+	for _, c := range installCopies(ctx.WorkDir) {
+		err := ctx.setAside(c.Dest)
+		ctx.assert(err)
+		err = ctx.exec.CopyFile(c)
+		if err != nil {
+			return errors.Errorf("copy file error: %+v err=%v", c, err)
+		}
+	}
+	ctx.exec.Chmod("/usr/bin/glitter", 0755)
+	ctx.exec.Chmod("/usr/bin/tendermint", 0755)
 
-	os.RemoveAll(pathJoin(installdir, "tendermint"))
-	os.RemoveAll(pathJoin(installdir, "glitter"))
-	os.RemoveAll(pathJoin("/tmp/kvstore"))
-	os.MkdirAll(pathJoin(installdir, "tendermint/config"), 0755)
-	os.MkdirAll(pathJoin(installdir, "tendermint/data"), 0755)
-	os.MkdirAll(pathJoin(installdir, "glitter"), 0755)
+	err := ctx.exec.Chown(installdir, glitterUser, glitterGroup, true)
+	ctx.assert(err)
 
-	tmConfigSrcPath := pathJoin(ctx.WorkDir, "tendermint-full.config.toml")
-	genesisSrcPath := pathJoin(ctx.WorkDir, "genesis.json")
-	nodeKeySrcPath := pathJoin(ctx.WorkDir, "node_key.json")
-	validatorKeySrcPath := pathJoin(ctx.WorkDir, "priv_validator_key.json")
-	validatorStateSrcPath := pathJoin(ctx.WorkDir, "priv_validator_state.json")
-	glitterConfigSrcPath := pathJoin(ctx.WorkDir, "glitter.config.toml")
+	err = ctx.exec.Chown("/usr/bin/glitter", glitterUser, glitterGroup, true)
+	ctx.assert(err)
+
+	err = ctx.exec.Chown("/usr/bin/tendermint", glitterUser, glitterGroup, true)
+	ctx.assert(err)
+
+	return ctx.exec.Systemctl(ctx.Context, "daemon-reload")
+}
+
+// installCopies lists the files staged in workDir and where init installs them.
+func installCopies(workDir string) []CopyFileDesc {
+	tmConfigSrcPath := pathJoin(workDir, "tendermint-full.config.toml")
+	genesisSrcPath := pathJoin(workDir, "genesis.json")
+	nodeKeySrcPath := pathJoin(workDir, "node_key.json")
+	validatorKeySrcPath := pathJoin(workDir, "priv_validator_key.json")
+	validatorStateSrcPath := pathJoin(workDir, "priv_validator_state.json")
+	glitterConfigSrcPath := pathJoin(workDir, "glitter.config.toml")
 
-	glitterServiceSrcPath := pathJoin(ctx.WorkDir, "glitter.service")
-	tmServiceSrcPath := pathJoin(ctx.WorkDir, "tendermint.service")
+	glitterServiceSrcPath := pathJoin(workDir, "glitter.service")
+	tmServiceSrcPath := pathJoin(workDir, "tendermint.service")
 
-	glitterBinSrcPath := pathJoin(ctx.WorkDir, "glitter")
-	tmBinSrcPath := pathJoin(ctx.WorkDir, "tendermint")
+	glitterBinSrcPath := pathJoin(workDir, "glitter")
+	tmBinSrcPath := pathJoin(workDir, "tendermint")
 
-	copys := []CopyFileDesc{
+	return []CopyFileDesc{
 		{tmConfigSrcPath, pathJoin(installdir, "tendermint/config", "config.toml")},
 		{genesisSrcPath, pathJoin(installdir, "tendermint/config", "genesis.json")},
 		{nodeKeySrcPath, pathJoin(installdir, "tendermint/config", "node_key.json")},
@@ -459,41 +910,47 @@ func stepResetCopyFile(ctx *setupNodeCtx) error {
 		{glitterBinSrcPath, "/usr/bin/glitter"},
 		{tmBinSrcPath, "/usr/bin/tendermint"},
 	}
-	for _, c := range copys {
-		err := copyFile(c)
-		if err != nil {
-			return errors.Errorf("copy file error: %+v err=%v", c, err)
-		}
-	}
-	os.Chmod("/usr/bin/glitter", 0755)
-	os.Chmod("/usr/bin/tendermint", 0755)
+}
+
+func stepSaveConfig(ctx *setupNodeCtx) error {
+	err := ctx.store.Set(keySeeds, ctx.SeedsStr)
+	ctx.assert(err)
 
-	err := chown(installdir, glitterUser, glitterGroup, true)
+	err = ctx.store.Set(keyMoniker, ctx.Moniker)
 	ctx.assert(err)
 
-	err = chown("/usr/bin/glitter", glitterUser, glitterGroup, true)
+	err = ctx.store.Set(keyTendermintVersion, ctx.TendermintVersion)
 	ctx.assert(err)
 
-	err = chown("/usr/bin/tendermint", glitterUser, glitterGroup, true)
+	err = ctx.store.Set(keyGlitterVersion, ctx.GlitterVersion)
 	ctx.assert(err)
 
-	return systemctl("daemon-reload")
+	err = ctx.store.Set(keyInitDone, "true")
+	ctx.assert(err)
+
+	return nil
 }
 
 func stepSwitchToFullNode(ctx *setupNodeCtx) error {
 	tmConfigSrcPath := pathJoin(ctx.WorkDir, "tendermint-full.config.toml")
-	err := copyFile(CopyFileDesc{tmConfigSrcPath, pathJoin(installdir, "tendermint/config", "config.toml")})
+	tmConfigPath := pathJoin(installdir, "tendermint/config", "config.toml")
+	err := ctx.setAside(tmConfigPath)
+	ctx.assert(err)
+	err = ctx.exec.CopyFile(CopyFileDesc{tmConfigSrcPath, tmConfigPath})
 	ctx.assert(err)
 
-	return systemctl("restart", "tendermint")
+	return ctx.exec.Systemctl(ctx.Context, "restart", "tendermint")
 }
 
 func stepSwitchToValidator(ctx *setupNodeCtx) error {
 	tmConfigSrcPath := pathJoin(ctx.WorkDir, "tendermint-validator.config.toml")
-	err := copyFile(CopyFileDesc{tmConfigSrcPath, pathJoin(installdir, "tendermint/config", "config.toml")})
+	tmConfigPath := pathJoin(installdir, "tendermint/config", "config.toml")
+	err := ctx.setAside(tmConfigPath)
+	ctx.assert(err)
+	err = ctx.exec.CopyFile(CopyFileDesc{tmConfigSrcPath, tmConfigPath})
 	ctx.assert(err)
 
-	return systemctl("restart", "tendermint")
+	return ctx.exec.Systemctl(ctx.Context, "restart", "tendermint")
 }
 
 func stepWaitForValidator(ctx *setupNodeCtx) error {
@@ -502,15 +959,21 @@ func stepWaitForValidator(ctx *setupNodeCtx) error {
 	if stage == "ok" {
 		return nil
 	}
+	if ctx.dryRun {
+		ctx.report(Event{Type: EventPlan, Message: "wait until " + ctx.LocalTendermintRPCURL + " joins the validator set"})
+		return nil
+	}
 
-	time.Sleep(time.Second * 5)
+	err = sleepContext(ctx.Context, time.Second*5)
+	ctx.assert(err)
 	address, err := ctx.store.Get(keyPubKeyAddress)
 	ctx.assert(err)
 
 	errCnt := 0
 	for {
-		time.Sleep(time.Second)
-		resp, err := ctx.tmLocalClient.Validators(context.TODO(), nil, nil, nil)
+		err = sleepContext(ctx.Context, time.Second)
+		ctx.assert(err)
+		resp, err := ctx.tmLocalClient.Validators(ctx.Context, nil, nil, nil)
 		if err != nil {
 			if errCnt > 10 {
 				return err
@@ -530,6 +993,10 @@ func stepWaitForValidator(ctx *setupNodeCtx) error {
 }
 
 type setupNodeCtx struct {
+	// Context is canceled when the operation is aborted or the current step
+	// times out.
+	Context context.Context
+
 	WorkDir  string
 	StoreDir string
 
@@ -540,9 +1007,30 @@ type setupNodeCtx struct {
 	TendermintBinaryURL string
 	GlitterBinaryURL    string
 
+	TendermintBinarySHA256 string
+	GlitterBinarySHA256    string
+	FetchSHA256Sums        bool
+
+	TendermintBinPathInArchive string
+	GlitterBinPathInArchive    string
+
+	TendermintVersion string
+	GlitterVersion    string
+
+	GenesisFile   string
+	GenesisURL    string
+	GenesisSHA256 string
+	ChainID       string
+
 	Seeds    []*NodeAddr
 	SeedsStr string
 
+	StateSync   bool
+	TrustPeriod time.Duration
+	// ClusterRPCs are the tendermint RPC endpoints of the cluster, in order
+	// of preference.
+	ClusterRPCs []string
+
 	OldClusterTendermintRPCURL string
 	OldClusterGlitterURL       string
 	LocalTendermintRPCURL      string
@@ -556,14 +1044,104 @@ type setupNodeCtx struct {
 	store           store
 	tmClusterClient *TendermintClient
 	tmLocalClient   *TendermintClient
+
+	undo    []undoAction
+	commits []func() error
+	// step is the step running, it owns the undo actions registered.
+	step string
+
+	// httpClient is used for downloads, rpcClient for the cluster RPC.
+	httpClient *http.Client
+	rpcClient  *http.Client
+
+	// importedValidator is the decrypted key file of init
+	// --import-validator-key.
+	importedValidator *exportedValidator
+	importedKey       *privval.FilePVKey
+
+	// genesis is the genesis of the node being initialized.
+	genesis *tmtypes.GenesisDoc
+	// stateSync is the state sync trust resolved ahead of the tendermint
+	// config, by apply from its plan.
+	stateSync *stateSyncTrust
+
+	// bundle is the offline bundle init installs from, if any.
+	bundle *nodeBundle
+
+	exec     executor
+	dryRun   bool
+	reporter Reporter
+}
+
+func (c *setupNodeCtx) report(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	c.reporter.Report(e)
+}
+
+func (c *setupNodeCtx) warn(format string, a ...interface{}) {
+	c.report(Event{Type: EventWarn, Message: fmt.Sprintf(format, a...)})
+}
+
+func (c *setupNodeCtx) openStore(createIfNotExist bool) error {
+	s, err := newFileStore(storedir, createIfNotExist)
+	if os.IsNotExist(err) {
+		return errors.Wrap(ErrNotInitialized, "please init node first")
+	}
+	if err != nil {
+		return err
+	}
+	c.store = s
+	if c.dryRun {
+		c.store = newDryRunStore(s)
+	}
+	return nil
 }
 
 /* setupNodePipe */
 
 type nodeOpsPipe struct {
-	ctx  setupNodeCtx
-	step string
-	err  error
+	ctx   setupNodeCtx
+	step  string
+	err   error
+	start time.Time
+
+	base        context.Context
+	stepTimeout time.Duration
+	deadline    time.Time
+
+	checkpoint *stepCheckpoint
+	finished   []string
+}
+
+func newNodeOpsPipe(ctx context.Context, args NodeOpsArgs) *nodeOpsPipe {
+	p := &nodeOpsPipe{base: ctx, stepTimeout: args.StepTimeout}
+	p.ctx.Context = ctx
+	p.ctx.reporter = args.Reporter
+	if p.ctx.reporter == nil {
+		p.ctx.reporter = NewTextReporter(os.Stdout)
+	}
+	p.ctx.httpClient = http.DefaultClient
+	p.ctx.rpcClient = http.DefaultClient
+	if err := p.ctx.configureHTTP(args); err != nil {
+		p.step = "Configure HTTP"
+		p.start = time.Now()
+		p.err = err
+		p.fail()
+	}
+	dl := newDownloader(p.ctx.httpClient, args.DownloadMirrors, args.DownloadRetries, p.ctx.report)
+	p.ctx.exec = hostExecutor{dl: dl}
+	if args.DryRun {
+		p.ctx.exec = newPlanExecutor(p.ctx.report)
+		p.ctx.dryRun = true
+	}
+	return p
+}
+
+type nodeOpsStep struct {
+	Name string
+	Run  func(ctx *setupNodeCtx) error
 }
 
 func (p *nodeOpsPipe) Do(step string, f func(ctx *setupNodeCtx) error) (pp *nodeOpsPipe) {
@@ -572,8 +1150,118 @@ func (p *nodeOpsPipe) Do(step string, f func(ctx *setupNodeCtx) error) (pp *node
 		return p
 	}
 	p.step = step
-	fmt.Printf("[step] %s\n", step)
+	if p.checkpoint != nil {
+		skip, err := p.checkpoint.skip(p.ctx.store, step)
+		p.ctx.assert(err)
+		if skip {
+			p.ctx.report(Event{Type: EventStepSkip, Step: step})
+			return p
+		}
+	}
+	p.start = time.Now()
+	p.ctx.report(Event{Type: EventStepStart, Step: step, Time: p.start})
+	if err := p.base.Err(); err != nil {
+		p.err = err
+		p.fail()
+		return p
+	}
+	p.ctx.Context = p.base
+	p.ctx.step = step
+	p.deadline = time.Time{}
+	if p.stepTimeout > 0 {
+		p.deadline = p.start.Add(p.stepTimeout)
+		stepCtx, cancel := context.WithDeadline(p.base, p.deadline)
+		defer cancel()
+		p.ctx.Context = stepCtx
+	}
 	p.err = f(&p.ctx)
+	if p.err != nil {
+		p.fail()
+		return p
+	}
+	if p.checkpoint != nil {
+		p.ctx.assert(p.checkpoint.done(p.ctx.store, step))
+		p.finished = append(p.finished, step)
+	}
+	p.ctx.report(Event{Type: EventStepDone, Step: step, DurationMS: sinceMS(p.start)})
+	return p
+}
+
+// fail reports the failure of the current step and rolls back.
+func (p *nodeOpsPipe) fail() {
+	// Make an abort or a step timeout visible in the error chain even when
+	// the step reports it as a plain error.
+	if cerr := p.abortErr(); cerr != nil && !errors.Is(p.err, cerr) {
+		p.err = errors.Wrap(cerr, p.err.Error())
+	}
+	p.ctx.report(Event{Type: EventStepFailed, Step: p.step, DurationMS: sinceMS(p.start), Error: p.err.Error()})
+	p.rollback()
+}
+
+// abortErr returns why the current step was aborted, if it was.
+func (p *nodeOpsPipe) abortErr() error {
+	if err := p.base.Err(); err != nil {
+		return err
+	}
+	if !p.deadline.IsZero() && !time.Now().Before(p.deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+func sinceMS(t time.Time) float64 {
+	return float64(time.Since(t)) / float64(time.Millisecond)
+}
+
+// Commit runs the commit actions registered by the steps if all of them
+// succeeded. It must be called at the end of every pipeline that may have
+// set files aside.
+func (p *nodeOpsPipe) Commit() *nodeOpsPipe {
+	if p.err != nil {
+		return p
+	}
+	for _, f := range p.ctx.commits {
+		if err := f(); err != nil {
+			p.ctx.warn("failed to clean up backup: %v", err)
+		}
+	}
+	p.ctx.undo = nil
+	p.ctx.commits = nil
+	return p
+}
+
+// rollback unwinds the undo actions registered so far in reverse order and
+// forgets the checkpoints of the steps it undid. Finished steps without undo
+// actions keep their checkpoint, so that a resume does not redo them.
+func (p *nodeOpsPipe) rollback() {
+	undo := p.ctx.undo
+	p.ctx.undo = nil
+	p.ctx.commits = nil
+	undone := map[string]bool{}
+	for i := len(undo) - 1; i >= 0; i-- {
+		e := Event{Type: EventRollback, Message: undo[i].desc}
+		if err := undo[i].f(); err != nil {
+			e.Error = err.Error()
+		}
+		p.ctx.report(e)
+		undone[undo[i].step] = true
+	}
+	if p.checkpoint != nil {
+		for _, step := range p.finished {
+			if undone[step] {
+				p.checkpoint.forget(p.ctx.store, step)
+			}
+		}
+	}
+	p.finished = nil
+}
+
+// WithCheckpoint makes the following steps record their completion in the
+// store and skip according to cp. It must be called once the store is open.
+func (p *nodeOpsPipe) WithCheckpoint(cp *stepCheckpoint) *nodeOpsPipe {
+	if p.err == nil {
+		p.checkpoint = cp
+	}
 	return p
 }
 
@@ -594,6 +1282,7 @@ func (p *nodeOpsPipe) tryRecover(v **nodeOpsPipe) {
 	}
 	if e, ok := iv.(pipeError); ok {
 		p.err = e
+		p.fail()
 		*v = p
 		return
 	}
@@ -604,5 +1293,9 @@ func (p *nodeOpsPipe) Error() error {
 	if p.err == nil {
 		return nil
 	}
-	return errors.Errorf("failed to execute setp [%s]: %v", p.step, p.err)
+	return &StepError{
+		Step:      p.step,
+		Cause:     p.err,
+		Retryable: isRetryable(p.err),
+	}
 }
@@ -0,0 +1,62 @@
+package glitterboot
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// PeerCheck is the validation result of one entry of a peer list.
+type PeerCheck struct {
+	Input string `json:"input"`
+	// Peer is the normalised entry.
+	Peer string `json:"peer,omitempty"`
+	// Addrs are the IP addresses the host resolves to.
+	Addrs     []string `json:"addrs,omitempty"`
+	Duplicate bool     `json:"duplicate,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// peersValidateOps checks the syntax of the peer list args.Seeds and
+// resolves the host of every peer, reporting all the entries before it
+// fails on the first invalid or unresolvable one.
+func peersValidateOps(ctx context.Context, args NodeOpsArgs) error {
+	p := newNodeOpsPipe(ctx, args)
+	p.Do("Validate peers", func(ctx *setupNodeCtx) error {
+		entries := splitNodeAddrs(args.Seeds)
+		if len(entries) == 0 {
+			return errors.Wrap(ErrInvalidArgument, "peers: at least provide one peer")
+		}
+		checks := make([]PeerCheck, len(entries))
+		var invalid, unresolved error
+		for i, e := range entries {
+			c := &checks[i]
+			c.Input = e.input
+			if e.err != nil {
+				c.Error = e.err.Error()
+				if invalid == nil {
+					invalid = e.err
+				}
+				continue
+			}
+			c.Peer = e.addr.String()
+			c.Duplicate = e.duplicate
+			addrs, err := e.addr.resolve(ctx.Context, peerResolver)
+			if err != nil {
+				c.Error = err.Error()
+				if unresolved == nil {
+					unresolved = errors.Wrapf(err, "peer %s", c.Peer)
+				}
+				continue
+			}
+			c.Addrs = addrs
+		}
+		ctx.report(Event{Type: EventPeers, Peers: checks})
+
+		if invalid != nil {
+			return invalid
+		}
+		return unresolved
+	})
+	return p.Error()
+}
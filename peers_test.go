@@ -0,0 +1,213 @@
+package glitterboot
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	testID      = "2e73e0491df978d11f3d928a36b635a4e94ef927"
+	testOtherID = "0123456789abcdef0123456789abcdef01234567"
+)
+
+func TestParseNodeAddr(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // normalised address, empty if invalid
+	}{
+		{testID + "@1.2.3.4:26656", testID + "@1.2.3.4:26656"},
+		{" " + testID + "@1.2.3.4:26656 ", testID + "@1.2.3.4:26656"},
+		{strings.ToUpper(testID) + "@1.2.3.4:26656", testID + "@1.2.3.4:26656"},
+		{testID + "@Seed.Example.COM.:26656", testID + "@seed.example.com:26656"},
+		{testID + "@[2001:DB8::1]:26656", testID + "@[2001:db8::1]:26656"},
+		{testID + "@[::ffff:1.2.3.4]:26656", testID + "@1.2.3.4:26656"},
+		{testID + "@1.2.3.4:026656", testID + "@1.2.3.4:26656"},
+		{testID + "@1.2.3.4:1", testID + "@1.2.3.4:1"},
+		{testID + "@1.2.3.4:65535", testID + "@1.2.3.4:65535"},
+
+		{testID + "@2001:db8::1:26656", ""},
+		{testID + "@[2001:db8::1]", ""},
+		{testID + "@1.2.3.4:0", ""},
+		{testID + "@1.2.3.4:65536", ""},
+		{testID + "@1.2.3.4:port", ""},
+		{testID + "@1.2.3.4", ""},
+		{testID[:39] + "@1.2.3.4:26656", ""},
+		{testID + "00@1.2.3.4:26656", ""},
+		{"zz" + testID[2:] + "@1.2.3.4:26656", ""},
+		{"@1.2.3.4:26656", ""},
+		{"1.2.3.4:26656", ""},
+		{testID + "@" + testID + "@1.2.3.4:26656", ""},
+		{testID + "@-seed.example.com:26656", ""},
+		{testID + "@seed..example.com:26656", ""},
+		{testID + "@seed example.com:26656", ""},
+		{testID + "@:26656", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			addr, err := parseNodeAddr(tt.in)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("accepted as %s", addr)
+				}
+				if !errors.Is(err, ErrInvalidArgument) {
+					t.Fatalf("got %v, want an invalid argument", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := addr.String(); got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNodeAddrs(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+		err  string
+	}{
+		{"empty entries", " ," + testID + "@1.2.3.4:26656,, ,", []string{testID + "@1.2.3.4:26656"}, ""},
+		{"duplicates", testID + "@1.2.3.4:26656," + strings.ToUpper(testID) + "@1.2.3.4:26656",
+			[]string{testID + "@1.2.3.4:26656"}, ""},
+		{"two nodes", testID + "@1.2.3.4:26656," + testOtherID + "@1.2.3.4:26657",
+			[]string{testID + "@1.2.3.4:26656", testOtherID + "@1.2.3.4:26657"}, ""},
+		{"same id, other address", testID + "@1.2.3.4:26656," + testID + "@1.2.3.5:26656", nil, "already listed"},
+		{"same id, other port", testID + "@1.2.3.4:26656," + testID + "@1.2.3.4:26657", nil, "already listed"},
+		{"invalid entry", testID + "@1.2.3.4:26656,nope", nil, "must be id@host:port"},
+		{"nothing", " , ", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addrs, err := parseNodeAddrs(tt.in)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want an error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got []string
+			for _, a := range addrs {
+				got = append(got, a.String())
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// stubResolver resolves the host names it knows, and fails like a DNS
+// server without the name for the others.
+type stubResolver map[string][]string
+
+func (r stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := r[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// eventRecorder is a Reporter keeping the events reported.
+type eventRecorder []Event
+
+func (r *eventRecorder) Report(e Event) {
+	*r = append(*r, e)
+}
+
+func (r eventRecorder) peers() []PeerCheck {
+	for _, e := range r {
+		if e.Type == EventPeers {
+			return e.Peers
+		}
+	}
+	return nil
+}
+
+func TestPeersValidate(t *testing.T) {
+	old := peerResolver
+	peerResolver = stubResolver{"seed.example.com": {"10.0.0.1", "10.0.0.2"}}
+	t.Cleanup(func() { peerResolver = old })
+
+	tests := []struct {
+		name   string
+		seeds  string
+		checks []PeerCheck
+		err    string
+	}{
+		{
+			name:  "resolved",
+			seeds: testID + "@Seed.Example.com:26656," + testOtherID + "@[::1]:26656," + testID + "@seed.example.com:26656",
+			checks: []PeerCheck{
+				{Peer: testID + "@seed.example.com:26656", Addrs: []string{"10.0.0.1", "10.0.0.2"}},
+				{Peer: testOtherID + "@[::1]:26656", Addrs: []string{"::1"}},
+				{Peer: testID + "@seed.example.com:26656", Addrs: []string{"10.0.0.1", "10.0.0.2"}, Duplicate: true},
+			},
+		},
+		{
+			name:  "dns failure",
+			seeds: testID + "@seed.example.com:26656," + testOtherID + "@unknown.example.com:26656",
+			checks: []PeerCheck{
+				{Peer: testID + "@seed.example.com:26656", Addrs: []string{"10.0.0.1", "10.0.0.2"}},
+				{Peer: testOtherID + "@unknown.example.com:26656", Error: "no such host"},
+			},
+			err: "no such host",
+		},
+		{
+			name:  "invalid before unresolved",
+			seeds: testOtherID + "@unknown.example.com:26656," + testID + "@1.2.3.4:0",
+			checks: []PeerCheck{
+				{Peer: testOtherID + "@unknown.example.com:26656", Error: "no such host"},
+				{Error: "port must be"},
+			},
+			err: "port must be",
+		},
+		{
+			name:  "empty",
+			seeds: " , ",
+			err:   "at least provide one peer",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events eventRecorder
+			err := NodeOperate(context.Background(), NodeOpsArgs{
+				Type:     OpsPeersValidate,
+				Seeds:    tt.seeds,
+				Reporter: &events,
+			})
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("got %v, want an error containing %q", err, tt.err)
+			}
+
+			got := events.peers()
+			if len(got) != len(tt.checks) {
+				t.Fatalf("got %d checks, want %d: %+v", len(got), len(tt.checks), got)
+			}
+			for i, want := range tt.checks {
+				c := got[i]
+				if c.Peer != want.Peer || c.Duplicate != want.Duplicate ||
+					strings.Join(c.Addrs, ",") != strings.Join(want.Addrs, ",") {
+					t.Errorf("check %d: got %+v, want %+v", i, c, want)
+				}
+				if (want.Error == "") != (c.Error == "") || !strings.Contains(c.Error, want.Error) {
+					t.Errorf("check %d: got error %q, want one containing %q", i, c.Error, want.Error)
+				}
+			}
+		})
+	}
+}
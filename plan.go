@@ -0,0 +1,253 @@
+package glitterboot
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const nodePlanVersion = 1
+
+// nodePlan is the reviewed artifact written by plan and executed by apply.
+type nodePlan struct {
+	Version   int               `json:"version"`
+	CreatedAt time.Time         `json:"created_at"`
+	Args      NodeOpsArgs       `json:"args"`
+	Resolved  resolvedNodeCtx   `json:"resolved"`
+	Steps     []string          `json:"steps"`
+	HostState map[string]string `json:"host_state"`
+}
+
+// resolvedNodeCtx is the part of setupNodeCtx derived from the arguments
+// during Prepare.
+type resolvedNodeCtx struct {
+	Moniker                    string   `json:"moniker"`
+	IndexMode                  string   `json:"index_mode"`
+	Seeds                      []string `json:"seeds"`
+	TendermintBinaryURL        string   `json:"tendermint_binary_url"`
+	GlitterBinaryURL           string   `json:"glitter_binary_url"`
+	TendermintBinarySHA256     string   `json:"tendermint_binary_sha256"`
+	GlitterBinarySHA256        string   `json:"glitter_binary_sha256"`
+	TendermintVersion          string   `json:"tendermint_version"`
+	GlitterVersion             string   `json:"glitter_version"`
+	OldClusterTendermintRPCURL string   `json:"cluster_tendermint_rpc_url"`
+	OldClusterGlitterURL       string   `json:"cluster_glitter_url"`
+	LocalTendermintRPCURL      string   `json:"local_tendermint_rpc_url"`
+	ClusterRPCs                []string `json:"cluster_rpcs"`
+	// ChainID is the chain id of the genesis init installs.
+	ChainID   string          `json:"chain_id"`
+	StateSync *stateSyncTrust `json:"state_sync,omitempty"`
+}
+
+func planNode(ctx context.Context, args NodeOpsArgs) error {
+	args.Type = OpsInit
+	args.DryRun = true
+	cp, err := newStepCheckpoint(args, initNodeSteps)
+	if err != nil {
+		return err
+	}
+
+	p := newNodeOpsPipe(ctx, args)
+	p.
+		Do("Prepare", prepareInitNode(args)).
+		Do("Resolve genesis", func(ctx *setupNodeCtx) error {
+			return resolveRemote(ctx, 0)
+		}).
+		Do("Write plan", func(ctx *setupNodeCtx) error {
+			state, err := hostState()
+			ctx.assert(err)
+			plan := nodePlan{
+				Version:   nodePlanVersion,
+				CreatedAt: time.Now().UTC(),
+				Args:      args,
+				Resolved:  resolveNodeCtx(ctx),
+				Steps:     plannedSteps(ctx, cp),
+				HostState: state,
+			}
+			b, err := json.MarshalIndent(plan, "", "  ")
+			ctx.assert(err)
+			return ioutil.WriteFile(args.PlanFile, b, 0644)
+		}).
+		Commit()
+
+	return p.Error()
+}
+
+func applyPlan(ctx context.Context, args NodeOpsArgs) error {
+	plan, err := loadNodePlan(args.PlanFile)
+	if err != nil {
+		return err
+	}
+
+	state, err := hostState()
+	if err != nil {
+		return err
+	}
+	if changed := changedHostState(plan.HostState, state); len(changed) > 0 {
+		return errors.Wrapf(ErrHostChanged, "refusing to apply:\n  %s", strings.Join(changed, "\n  "))
+	}
+
+	plan.Args.Type = OpsInit
+	plan.Args.setRuntime(args)
+	return runInitNode(ctx, plan.Args, func(ctx *setupNodeCtx, cp *stepCheckpoint) error {
+		var trustHeight int64
+		if plan.Resolved.StateSync != nil {
+			trustHeight = plan.Resolved.StateSync.Height
+		}
+		if err := resolveRemote(ctx, trustHeight); err != nil {
+			return err
+		}
+		if r := resolveNodeCtx(ctx); !reflect.DeepEqual(r, plan.Resolved) {
+			return errors.Wrapf(ErrHostChanged, "resolved node context differs from the plan: planned %+v, got %+v", plan.Resolved, r)
+		}
+		if steps := plannedSteps(ctx, cp); !reflect.DeepEqual(steps, plan.Steps) {
+			return errors.Wrapf(ErrHostChanged, "steps differ from the plan: planned %v, got %v", plan.Steps, steps)
+		}
+		return nil
+	})
+}
+
+// setRuntime copies the fields of r that are not saved in a plan, such as
+// the output, network and passphrase settings of the command running apply.
+func (a *NodeOpsArgs) setRuntime(r NodeOpsArgs) {
+	a.Proxy = r.Proxy
+	a.CABundle = r.CABundle
+	a.ClientCert = r.ClientCert
+	a.ClientKey = r.ClientKey
+	a.Passphrase = r.Passphrase
+	a.DryRun = r.DryRun
+	a.Reporter = r.Reporter
+	a.StepTimeout = r.StepTimeout
+	a.DownloadMirrors = r.DownloadMirrors
+	a.DownloadRetries = r.DownloadRetries
+}
+
+func loadNodePlan(path string) (*nodePlan, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidArgument, err.Error())
+	}
+	plan := &nodePlan{}
+	if err := json.Unmarshal(b, plan); err != nil {
+		return nil, errors.Wrapf(ErrInvalidArgument, "plan file %s: %v", path, err)
+	}
+	if plan.Version != nodePlanVersion {
+		return nil, errors.Wrapf(ErrInvalidArgument, "unsupported plan version %d", plan.Version)
+	}
+	return plan, nil
+}
+
+func resolveNodeCtx(ctx *setupNodeCtx) resolvedNodeCtx {
+	r := resolvedNodeCtx{
+		Moniker:                    ctx.Moniker,
+		IndexMode:                  ctx.IndexMode,
+		TendermintBinaryURL:        ctx.TendermintBinaryURL,
+		GlitterBinaryURL:           ctx.GlitterBinaryURL,
+		TendermintBinarySHA256:     ctx.TendermintBinarySHA256,
+		GlitterBinarySHA256:        ctx.GlitterBinarySHA256,
+		TendermintVersion:          ctx.TendermintVersion,
+		GlitterVersion:             ctx.GlitterVersion,
+		OldClusterTendermintRPCURL: ctx.OldClusterTendermintRPCURL,
+		OldClusterGlitterURL:       ctx.OldClusterGlitterURL,
+		LocalTendermintRPCURL:      ctx.LocalTendermintRPCURL,
+		ClusterRPCs:                ctx.ClusterRPCs,
+		StateSync:                  ctx.stateSync,
+	}
+	if ctx.genesis != nil {
+		r.ChainID = ctx.genesis.ChainID
+	}
+	for _, s := range ctx.Seeds {
+		r.Seeds = append(r.Seeds, s.String())
+	}
+	return r
+}
+
+// resolveRemote takes what init reads from the network ahead of its steps:
+// the genesis and, with state sync, the trust header. apply passes the
+// trust height of its plan, so that a different header at that height is
+// detected instead of a newer height being taken.
+func resolveRemote(ctx *setupNodeCtx, trustHeight int64) error {
+	_, doc, err := fetchGenesis(ctx)
+	if err != nil {
+		return err
+	}
+	ctx.genesis = doc
+	if !ctx.StateSync {
+		return nil
+	}
+	ctx.stateSync, err = fetchStateSyncTrust(ctx, trustHeight)
+	return err
+}
+
+// plannedSteps lists the init steps that would run given the checkpoints in
+// the store, without consuming cp.
+func plannedSteps(ctx *setupNodeCtx, cp *stepCheckpoint) []string {
+	c := *cp
+	var steps []string
+	for _, s := range initNodeSteps {
+		skip, err := c.skip(ctx.store, s.Name)
+		ctx.assert(err)
+		if !skip {
+			steps = append(steps, s.Name)
+		}
+	}
+	return steps
+}
+
+// hostState fingerprints what init reads and replaces: the store, every
+// installed file and the state of the services.
+func hostState() (map[string]string, error) {
+	paths := []string{storedir}
+	for _, c := range installCopies(bootdir) {
+		paths = append(paths, c.Dest)
+	}
+
+	state := map[string]string{}
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			state[path] = "absent"
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(b)
+		state[path] = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	for _, unit := range []string{"tendermint", "glitter"} {
+		status, _ := systemctlOut("is-active", unit)
+		status = strings.TrimSpace(status)
+		if status == "" {
+			status = "inactive"
+		}
+		state["systemd:"+unit] = status
+	}
+	return state, nil
+}
+
+func changedHostState(planned, current map[string]string) []string {
+	var changed []string
+	for k, v := range current {
+		if planned[k] != v {
+			changed = append(changed, fmt.Sprintf("%s: planned %q, now %q", k, planned[k], v))
+		}
+	}
+	for k, v := range planned {
+		if _, ok := current[k]; !ok {
+			changed = append(changed, fmt.Sprintf("%s: planned %q, now not checked", k, v))
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,89 @@
+package glitterboot
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tendermint/tendermint/crypto/ed25519"
+	"github.com/tendermint/tendermint/p2p"
+	"github.com/tendermint/tendermint/p2p/conn"
+)
+
+const preflightTimeout = 10 * time.Second
+
+// SeedCheck is the preflight result of one seed.
+type SeedCheck struct {
+	Seed string `json:"seed"`
+	OK   bool   `json:"ok"`
+	// NodeID is the ID the seed proved in the handshake.
+	NodeID    string  `json:"node_id,omitempty"`
+	LatencyMS float64 `json:"latency_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// stepPreflight dials the P2P port of every seed at once and runs the
+// secret connection handshake to check that the seed is the node its ID
+// says. It fails unless at least one seed passes.
+func stepPreflight(ctx *setupNodeCtx) error {
+	checks := make([]SeedCheck, len(ctx.Seeds))
+	errs := make([]error, len(ctx.Seeds))
+	var wg sync.WaitGroup
+	for i, seed := range ctx.Seeds {
+		wg.Add(1)
+		go func(i int, seed *NodeAddr) {
+			defer wg.Done()
+			checks[i].Seed = seed.String()
+			start := time.Now()
+			id, err := handshakeNode(ctx.Context, seed)
+			checks[i].NodeID = string(id)
+			if err != nil {
+				checks[i].Error = err.Error()
+				errs[i] = err
+				return
+			}
+			checks[i].OK = true
+			checks[i].LatencyMS = sinceMS(start)
+		}(i, seed)
+	}
+	wg.Wait()
+	ctx.report(Event{Type: EventPreflight, Seeds: checks})
+
+	for _, check := range checks {
+		if check.OK {
+			return nil
+		}
+	}
+	return errors.Wrap(errs[0], "no seed passed the preflight check")
+}
+
+// handshakeNode connects to the P2P address of n with a throwaway key and
+// returns the ID of the node that answered, failing if it is not n.
+func handshakeNode(ctx context.Context, n *NodeAddr) (p2p.ID, error) {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "tcp", net.JoinHostPort(n.Host, n.Port))
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	deadline, _ := ctx.Deadline()
+	if err := c.SetDeadline(deadline); err != nil {
+		return "", err
+	}
+
+	sc, err := conn.MakeSecretConnection(c, ed25519.GenPrivKey())
+	if err != nil {
+		return "", errors.Errorf("secret connection handshake: %v", err)
+	}
+	id := p2p.PubKeyToID(sc.RemotePubKey())
+	if string(id) != strings.ToLower(n.Address) {
+		return id, errors.Errorf("node ID is %s, not %s", id, n.Address)
+	}
+	return id, nil
+}
@@ -0,0 +1,136 @@
+package glitterboot
+
+import (
+	"context"
+	"crypto/ed25519"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//go:embed keys/release_ed25519.pub
+var embeddedReleaseKey string
+
+// releaseKeyOverride, when set at link time with
+// -ldflags "-X github.com/glitternetwork/glitter-boot.releaseKeyOverride=<base64 key>",
+// replaces the embedded release key, e.g. for builds of a private network.
+var releaseKeyOverride string
+
+// releasePublicKey verifies release manifests, nil if the build has no valid
+// key. Tests replace it.
+var releasePublicKey = decodeReleaseKey()
+
+func decodeReleaseKey() ed25519.PublicKey {
+	key := embeddedReleaseKey
+	if releaseKeyOverride != "" {
+		key = releaseKeyOverride
+	}
+	pub, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil
+	}
+	return ed25519.PublicKey(pub)
+}
+
+// releaseManifest lists the artifacts of glitter releases. A manifest at
+// <src> is signed by <src>.sig, the base64 ed25519 signature of its bytes.
+type releaseManifest struct {
+	Artifacts []releaseArtifact `json:"artifacts"`
+}
+
+type releaseArtifact struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	OS      string `json:"os"`
+	Arch    string `json:"arch"`
+	URL     string `json:"url"`
+	SHA256  string `json:"sha256"`
+}
+
+// loadSignedManifest reads the manifest at src, a URL or a local path, and
+// verifies its signature with the embedded release key.
+func loadSignedManifest(ctx context.Context, client *http.Client, src string) (*releaseManifest, error) {
+	if releasePublicKey == nil {
+		return nil, errors.New("this build of glitter-boot has no valid release public key embedded")
+	}
+
+	b, err := readSource(ctx, client, src)
+	if err != nil {
+		return nil, errors.Errorf("failed to read release manifest: %v", err)
+	}
+	sigb, err := readSource(ctx, client, src+".sig")
+	if err != nil {
+		return nil, errors.Errorf("failed to read release manifest signature: %v", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigb)))
+	if err != nil {
+		return nil, errors.Errorf("invalid release manifest signature: %v", err)
+	}
+	if !ed25519.Verify(releasePublicKey, b, sig) {
+		return nil, errors.Errorf("release manifest %s is not signed by the glitter release key", src)
+	}
+
+	m := &releaseManifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, errors.Errorf("invalid release manifest: %v", err)
+	}
+	return m, nil
+}
+
+// find returns the artifact called name for the host platform. An empty
+// version matches any version, the first listed wins.
+func (m *releaseManifest) find(name, version string) (*releaseArtifact, error) {
+	for i, a := range m.Artifacts {
+		if a.Name != name || a.OS != runtime.GOOS || a.Arch != runtime.GOARCH {
+			continue
+		}
+		if version != "" && a.Version != version {
+			continue
+		}
+		if a.URL == "" || !validSHA256(strings.ToLower(a.SHA256)) {
+			return nil, errors.Errorf("release artifact %s %s has no url or a malformed sha256", a.Name, a.Version)
+		}
+		return &m.Artifacts[i], nil
+	}
+	if version == "" {
+		return nil, errors.Errorf("no %s release for %s/%s", name, runtime.GOOS, runtime.GOARCH)
+	}
+	return nil, errors.Errorf("no %s %s release for %s/%s", name, version, runtime.GOOS, runtime.GOARCH)
+}
+
+// loadReleaseIndex reads an unsigned release index, URL or local path, in
+// the manifest format.
+func loadReleaseIndex(ctx context.Context, client *http.Client, src string) (*releaseManifest, error) {
+	b, err := readSource(ctx, client, src)
+	if err != nil {
+		return nil, errors.Errorf("failed to read release index: %v", err)
+	}
+	m := &releaseManifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, errors.Errorf("invalid release index: %v", err)
+	}
+	return m, nil
+}
+
+// useReleaseArtifact points the download of a binary at the artifact of the
+// manifest matching version, any version if empty, and sets version to the
+// one picked. An explicit digest must agree with the manifest.
+func useReleaseArtifact(m *releaseManifest, name string, version, url, digest *string) error {
+	a, err := m.find(name, *version)
+	if err != nil {
+		return err
+	}
+	sum := strings.ToLower(a.SHA256)
+	if *digest != "" && *digest != sum {
+		return errors.Wrapf(ErrChecksumMismatch, "%s: --%s_bin_sha256 %s disagrees with the release manifest %s", name, name, *digest, sum)
+	}
+	*version = a.Version
+	*url = a.URL
+	*digest = sum
+	return nil
+}
@@ -0,0 +1,202 @@
+package glitterboot
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// releaseServer serves a release manifest, its signature and the artifacts
+// it lists from files, which the tests change between requests.
+type releaseServer struct {
+	*httptest.Server
+	files map[string][]byte
+}
+
+func newReleaseServer(t *testing.T) *releaseServer {
+	s := &releaseServer{files: map[string][]byte{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, ok := s.files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// publish serves a manifest for glitter and tendermint artifacts holding
+// content, with digest as their sha256, signed with key.
+func (s *releaseServer) publish(t *testing.T, key ed25519.PrivateKey, content []byte, digest string) {
+	m := releaseManifest{}
+	for _, name := range []string{"glitter", "tendermint"} {
+		m.Artifacts = append(m.Artifacts, releaseArtifact{
+			Name:    name,
+			Version: "v1.0.0",
+			OS:      runtime.GOOS,
+			Arch:    runtime.GOARCH,
+			URL:     s.URL + "/" + name,
+			SHA256:  digest,
+		})
+		s.files["/"+name] = content
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.files["/manifest.json"] = b
+	s.files["/manifest.json.sig"] = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, b)) + "\n")
+}
+
+func useTestReleaseKey(t *testing.T) ed25519.PrivateKey {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := releasePublicKey
+	releasePublicKey = pub
+	t.Cleanup(func() { releasePublicKey = old })
+	return priv
+}
+
+// TestEmbeddedReleaseKey keeps a build without the release key, which
+// refuses every manifest, from passing the tests unnoticed.
+func TestEmbeddedReleaseKey(t *testing.T) {
+	if decodeReleaseKey() == nil {
+		t.Fatal("no valid release key: commit the base64 ed25519 public key of the release signer to keys/release_ed25519.pub, or set releaseKeyOverride with -ldflags -X")
+	}
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// runTestStep runs f as the single step of a pipeline.
+func runTestStep(f func(ctx *setupNodeCtx) error) error {
+	p := newNodeOpsPipe(context.Background(), NodeOpsArgs{Reporter: NewTextReporter(ioutil.Discard)})
+	p.Do("Test", f)
+	return p.Error()
+}
+
+func TestLoadSignedManifest(t *testing.T) {
+	key := useTestReleaseKey(t)
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("binary")
+
+	tests := []struct {
+		name   string
+		change func(s *releaseServer)
+		ok     bool
+	}{
+		{"good signature", func(s *releaseServer) {}, true},
+		{"tampered manifest", func(s *releaseServer) {
+			b := s.files["/manifest.json"]
+			s.files["/manifest.json"] = append(append([]byte{}, b[:len(b)-1]...), ' ', '}')
+		}, false},
+		{"signed by another key", func(s *releaseServer) {
+			s.files["/manifest.json.sig"] = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(otherKey, s.files["/manifest.json"])))
+		}, false},
+		{"malformed signature", func(s *releaseServer) {
+			s.files["/manifest.json.sig"] = []byte("not base64!")
+		}, false},
+		{"missing signature", func(s *releaseServer) {
+			delete(s.files, "/manifest.json.sig")
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newReleaseServer(t)
+			s.publish(t, key, content, sha256Hex(content))
+			tt.change(s)
+
+			m, err := loadSignedManifest(context.Background(), http.DefaultClient, s.URL+"/manifest.json")
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(m.Artifacts) != 2 {
+					t.Fatalf("got %d artifacts, want 2", len(m.Artifacts))
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("manifest accepted")
+			}
+		})
+	}
+}
+
+func TestLoadSignedManifestWithoutKey(t *testing.T) {
+	key := useTestReleaseKey(t)
+	s := newReleaseServer(t)
+	s.publish(t, key, []byte("binary"), sha256Hex([]byte("binary")))
+	releasePublicKey = nil
+
+	if _, err := loadSignedManifest(context.Background(), http.DefaultClient, s.URL+"/manifest.json"); err == nil {
+		t.Fatal("manifest accepted without a release key")
+	}
+}
+
+func TestReleaseArtifactDownload(t *testing.T) {
+	key := useTestReleaseKey(t)
+	oldCache := cachedir
+	t.Cleanup(func() { cachedir = oldCache })
+
+	tests := []struct {
+		name     string
+		served   []byte
+		mismatch bool
+	}{
+		{"matching sha256", []byte("release binary"), false},
+		{"sha256 mismatch", []byte("tampered binary"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// An empty cache, so that the served file is downloaded.
+			cachedir = t.TempDir()
+			s := newReleaseServer(t)
+			s.publish(t, key, tt.served, sha256Hex([]byte("release binary")))
+			dest := filepath.Join(t.TempDir(), "tendermint")
+
+			err := runTestStep(func(ctx *setupNodeCtx) error {
+				err := resolveReleases(ctx, NodeOpsArgs{ReleaseManifest: s.URL + "/manifest.json"})
+				if err != nil {
+					return err
+				}
+				_, err = downloadBinary(ctx, dest, "tendermint", ctx.TendermintBinaryURL, ctx.TendermintBinarySHA256, "")
+				return err
+			})
+			if !tt.mismatch {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				b, err := ioutil.ReadFile(dest)
+				if err != nil || string(b) != "release binary" {
+					t.Fatalf("got %q, %v", b, err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrChecksumMismatch) {
+				t.Fatalf("got %v, want a checksum mismatch", err)
+			}
+		})
+	}
+}
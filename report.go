@@ -0,0 +1,260 @@
+package glitterboot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Event types reported while a node operation runs.
+const (
+	EventStepStart  = "step_start"
+	EventStepDone   = "step_done"
+	EventStepSkip   = "step_skip"
+	EventStepFailed = "step_failed"
+	EventPlan       = "plan"
+	EventRollback   = "rollback"
+	EventWarn       = "warn"
+	EventNodeInfo   = "node_info"
+	EventResult     = "result"
+	EventProgress   = "progress"
+	EventCacheEntry = "cache_entry"
+	EventPreflight  = "preflight"
+	EventPeers      = "peers"
+)
+
+// Event describes the progress or the outcome of a node operation.
+type Event struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Step       string    `json:"step,omitempty"`
+	DurationMS float64   `json:"duration_ms,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	// Detail holds multi-line output such as the diff of a planned copy.
+	Detail     string      `json:"detail,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	NodeInfo   *NodeInfo   `json:"node_info,omitempty"`
+	Result     *Result     `json:"result,omitempty"`
+	Progress   *Progress   `json:"progress,omitempty"`
+	CacheEntry *CacheEntry `json:"cache_entry,omitempty"`
+	Seeds      []SeedCheck `json:"seeds,omitempty"`
+	Peers      []PeerCheck `json:"peers,omitempty"`
+}
+
+// Progress is reported while a file is downloaded.
+type Progress struct {
+	URL   string `json:"url"`
+	Bytes int64  `json:"bytes"`
+	// Total is -1 when the server did not send the size.
+	Total int64 `json:"total"`
+	Done  bool  `json:"done,omitempty"`
+}
+
+// NodeInfo is reported by the show-node-info operation.
+type NodeInfo struct {
+	NodeID            string `json:"node_id"`
+	Moniker           string `json:"moniker"`
+	PubKey            string `json:"pub_key"`
+	Address           string `json:"address"`
+	ChainID           string `json:"chain_id"`
+	TendermintStatus  string `json:"tendermint_status"`
+	GlitterStatus     string `json:"glitter_status"`
+	GlitterVersion    string `json:"glitter_version"`
+	TendermintVersion string `json:"tendermint_version"`
+	PrivateKeyFile    string `json:"private_key_file"`
+	GlitterBootDir    string `json:"glitter_boot_dir"`
+	GlitterDir        string `json:"glitter_dir"`
+}
+
+// Result is the final outcome of a command.
+type Result struct {
+	Success   bool   `json:"success"`
+	ExitCode  int    `json:"exit_code"`
+	Step      string `json:"step,omitempty"`
+	Retryable bool   `json:"retryable,omitempty"`
+}
+
+// Reporter receives the events of a node operation.
+type Reporter interface {
+	Report(e Event)
+}
+
+// NewTextReporter returns a Reporter writing human readable lines to w.
+func NewTextReporter(w io.Writer) Reporter {
+	return &textReporter{w: w}
+}
+
+// NewJSONReporter returns a Reporter writing one JSON object per event to w.
+func NewJSONReporter(w io.Writer) Reporter {
+	return &jsonReporter{enc: json.NewEncoder(w)}
+}
+
+type textReporter struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func (r *textReporter) Report(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch e.Type {
+	case EventStepStart:
+		fmt.Fprintf(r.w, "[step] %s\n", e.Step)
+	case EventStepSkip:
+		fmt.Fprintf(r.w, "[skip] %s\n", e.Step)
+	case EventPlan:
+		fmt.Fprintf(r.w, "[plan] %s\n", e.Message)
+	case EventRollback:
+		if e.Error != "" {
+			fmt.Fprintf(r.w, "[rollback] failed to %s: %s\n", e.Message, e.Error)
+		} else {
+			fmt.Fprintf(r.w, "[rollback] %s\n", e.Message)
+		}
+	case EventWarn:
+		fmt.Fprintf(r.w, "[WARN] %s\n", e.Message)
+	case EventNodeInfo:
+		r.nodeInfo(e.NodeInfo)
+	case EventProgress:
+		r.progress(e.Progress)
+	case EventPreflight:
+		r.preflight(e.Seeds)
+	case EventPeers:
+		r.peers(e.Peers)
+	case EventCacheEntry:
+		c := e.CacheEntry
+		if c.Pruned {
+			fmt.Fprintf(r.w, "pruned %s\n", c.SHA256)
+		} else {
+			fmt.Fprintf(r.w, "%s  %10s  %s\n", c.SHA256, formatBytes(c.Size), c.ModTime.Format(time.RFC3339))
+		}
+	case EventResult:
+		if e.Message != "" {
+			fmt.Fprintln(r.w, e.Message)
+		}
+	}
+	if e.Detail != "" {
+		for _, line := range strings.Split(strings.TrimSuffix(e.Detail, "\n"), "\n") {
+			fmt.Fprintf(r.w, "       %s\n", line)
+		}
+	}
+}
+
+func (r *textReporter) nodeInfo(info *NodeInfo) {
+	const format = `
+NodeID:		%s
+Moniker:	%s
+
+PubKey:		%s
+Address:	%s
+ChainID:	%s
+
+Tendermint Status: %s
+Glitter	   Status: %s
+
+TendermintVersion: %s
+GlitterVersion:	   %s
+
+PrivateKeyFile:	%s
+GlitterBootDir:	%s
+GlitterDir:		%s
+
+`
+	fmt.Fprintf(r.w, format,
+		info.NodeID,
+		info.Moniker,
+		info.PubKey,
+		info.Address,
+		orUnknown(info.ChainID),
+		info.TendermintStatus,
+		info.GlitterStatus,
+		orUnknown(info.TendermintVersion),
+		orUnknown(info.GlitterVersion),
+		info.PrivateKeyFile,
+		info.GlitterBootDir,
+		info.GlitterDir,
+	)
+}
+
+func (r *textReporter) preflight(checks []SeedCheck) {
+	tw := tabwriter.NewWriter(r.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEED\tSTATUS\tNODE ID\tLATENCY\tERROR")
+	for _, c := range checks {
+		status, latency := "ok", fmt.Sprintf("%.0fms", c.LatencyMS)
+		if !c.OK {
+			status, latency = "FAILED", "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Seed, status, c.NodeID, latency, c.Error)
+	}
+	tw.Flush()
+}
+
+func (r *textReporter) peers(checks []PeerCheck) {
+	tw := tabwriter.NewWriter(r.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PEER\tSTATUS\tADDRESSES\tERROR")
+	for _, c := range checks {
+		peer, status := c.Peer, "ok"
+		switch {
+		case c.Peer == "":
+			peer, status = c.Input, "INVALID"
+		case c.Error != "":
+			status = "UNRESOLVED"
+		case c.Duplicate:
+			status = "duplicate"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", peer, status, strings.Join(c.Addrs, ","), c.Error)
+	}
+	tw.Flush()
+}
+
+// progress redraws a single progress bar line per download.
+func (r *textReporter) progress(p *Progress) {
+	const width = 30
+	done := width
+	percent := ""
+	if p.Total > 0 {
+		done = int(p.Bytes * width / p.Total)
+		percent = fmt.Sprintf(" %3d%%", p.Bytes*100/p.Total)
+	} else if !p.Done {
+		done = 0
+	}
+	fmt.Fprintf(r.w, "\r[download] [%s%s] %s%s",
+		strings.Repeat("=", done), strings.Repeat(" ", width-done), formatBytes(p.Bytes), percent)
+	if p.Done {
+		fmt.Fprintln(r.w)
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+type jsonReporter struct {
+	enc *json.Encoder
+	mu  sync.Mutex
+}
+
+func (r *jsonReporter) Report(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc.Encode(e)
+}
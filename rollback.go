@@ -0,0 +1,95 @@
+package glitterboot
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const backupSuffix = ".glitter-boot.bak"
+
+type undoAction struct {
+	desc string
+	f    func() error
+	// step is the step that registered the action.
+	step string
+}
+
+// onUndo registers f to run when a later step fails. Undo actions run in
+// reverse order of registration.
+func (c *setupNodeCtx) onUndo(desc string, f func() error) {
+	c.undo = append(c.undo, undoAction{desc: desc, f: f, step: c.step})
+}
+
+// onCommit registers f to run once the whole pipeline succeeded, typically
+// to drop backups that are no longer needed.
+func (c *setupNodeCtx) onCommit(f func() error) {
+	c.commits = append(c.commits, f)
+}
+
+// setAside moves path (file or directory) next to itself so that it can be
+// replaced. On failure the original is put back, on commit it is removed.
+// If path does not exist, whatever is created there is removed on failure.
+// A path set aside earlier in the same run is already covered by its undo
+// action and can be replaced again as is.
+func (c *setupNodeCtx) setAside(path string) error {
+	for _, u := range c.undo {
+		if u.desc == "restore "+path || u.desc == "remove "+path {
+			return nil
+		}
+	}
+	bak := path + backupSuffix
+	if _, err := os.Lstat(bak); err == nil {
+		return errors.Errorf("stale backup %s found from an interrupted run, restore or remove it first", bak)
+	}
+
+	_, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		c.onUndo("remove "+path, func() error {
+			return c.exec.RemoveAll(path)
+		})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := c.exec.Rename(path, bak); err != nil {
+		return err
+	}
+	c.onUndo("restore "+path, func() error {
+		if err := c.exec.RemoveAll(path); err != nil {
+			return err
+		}
+		return c.exec.Rename(bak, path)
+	})
+	c.onCommit(func() error {
+		return c.exec.RemoveAll(bak)
+	})
+	return nil
+}
+
+// stopUnit stops a systemd unit and starts it again on failure if it was
+// running before.
+func (c *setupNodeCtx) stopUnit(name string) error {
+	if unitActive(name) {
+		c.onUndo("start "+name, func() error {
+			return c.exec.Systemctl(context.Background(), "start", name)
+		})
+	}
+	return c.exec.Systemctl(c.Context, "stop", name)
+}
+
+// unitActive reports whether a systemd unit is running.
+func unitActive(name string) bool {
+	status, _ := systemctlOut("is-active", name)
+	return strings.TrimSpace(status) == "active"
+}
+
+// unitEnabled reports whether a systemd unit starts at boot.
+func unitEnabled(name string) bool {
+	status, _ := systemctlOut("is-enabled", name)
+	return strings.TrimSpace(status) == "enabled"
+}
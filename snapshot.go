@@ -0,0 +1,315 @@
+package glitterboot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pierrec/lz4/v4"
+	"github.com/pkg/errors"
+)
+
+// A snapshot is a tar archive, optionally gzip or lz4 compressed, holding
+// tendermint/data/ (or data/, as in snapshots of a tendermint home) and
+// glitter/, the glitter db_path.
+const (
+	snapshotTendermintData = "tendermint/data"
+	snapshotTendermintHome = "data"
+	snapshotGlitterDB      = "glitter"
+)
+
+// snapshotPreserved are the files of the installed directories that are
+// kept over the ones of a snapshot: the last signed height of the validator,
+// and the glitter config, which lives in the default db_path.
+var snapshotPreserved = []string{"priv_validator_state.json", "config.toml"}
+
+var dbPathRe = regexp.MustCompile(`(?m)^\s*db_path\s*=\s*"([^"]*)"`)
+
+// snapshotTarget is an extracted snapshot directory, the directory it
+// replaces and the directory of the snapshot it was extracted from.
+type snapshotTarget struct {
+	src    string
+	dest   string
+	prefix string
+}
+
+// restoreSnapshot replaces the tendermint data and the glitter database with
+// a snapshot streamed from args.SnapshotURL, restarting the services that
+// were running.
+func restoreSnapshot(ctx context.Context, args NodeOpsArgs) error {
+	var (
+		digest  string
+		dbPath  string
+		active  []string
+		targets []snapshotTarget
+	)
+	p := newNodeOpsPipe(ctx, args)
+	p.
+		Do("Check", func(ctx *setupNodeCtx) error {
+			ctx.WorkDir = bootdir
+			ctx.StoreDir = storedir
+
+			err := ctx.openStore(false)
+			if err != nil {
+				return err
+			}
+			done, err := ctx.store.Get(keyInitDone)
+			ctx.assert(err)
+			if done != "true" {
+				return errors.Wrap(ErrNotInitialized, "please init node first before restore a snapshot")
+			}
+			if err := ctx.checkNetwork(args.ChainID); err != nil {
+				return err
+			}
+
+			u, err := url.Parse(args.SnapshotURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return errors.Wrapf(ErrInvalidArgument, "snapshot url %q: must be an http(s) URL", args.SnapshotURL)
+			}
+			digest = strings.ToLower(args.SnapshotSHA256)
+			switch {
+			case digest != "":
+				if !validSHA256(digest) {
+					return errors.Wrapf(ErrInvalidArgument, "snapshot sha256 %q: must be 64 hex characters", digest)
+				}
+			case args.FetchSHA256Sums:
+				digest, err = lookupSHA256Sums(ctx.Context, ctx.httpClient, args.SnapshotURL)
+				if err != nil {
+					return err
+				}
+			default:
+				return errors.Wrap(ErrInvalidArgument, "snapshot: the sha256 of the archive is required")
+			}
+
+			dbPath, err = glitterDBPath()
+			return err
+		}).
+		Do("Stop services", func(ctx *setupNodeCtx) error {
+			for _, unit := range []string{"tendermint", "glitter"} {
+				if unitActive(unit) {
+					active = append(active, unit)
+				}
+				if err := ctx.stopUnit(unit); err != nil {
+					return err
+				}
+			}
+			return nil
+		}).
+		Do("Download snapshot", func(ctx *setupNodeCtx) error {
+			if ctx.dryRun {
+				ctx.report(Event{Type: EventPlan, Message: "stream " + args.SnapshotURL + " into " +
+					pathJoin(installdir, "tendermint/data") + " and " + dbPath + ", keeping " + strings.Join(snapshotPreserved, ", ")})
+				return nil
+			}
+			stager := &snapshotStager{ctx: ctx, tmData: pathJoin(installdir, "tendermint/data"), dbPath: dbPath}
+			if err := streamSnapshot(ctx, args.SnapshotURL, digest, stager.target); err != nil {
+				return err
+			}
+			if len(stager.targets) == 0 {
+				return errors.Wrap(ErrInvalidArgument, "snapshot is empty")
+			}
+			targets = stager.targets
+			return nil
+		}).
+		Do("Install snapshot", func(ctx *setupNodeCtx) error {
+			for _, t := range targets {
+				for _, name := range snapshotPreserved {
+					kept := filepath.Join(t.dest, name)
+					if _, err := os.Stat(kept); err != nil {
+						continue
+					}
+					err := ctx.exec.CopyFile(CopyFileDesc{kept, filepath.Join(t.src, name)})
+					ctx.assert(err)
+				}
+				err := ctx.setAside(t.dest)
+				ctx.assert(err)
+				err = ctx.exec.MkdirAll(filepath.Dir(t.dest), 0755)
+				ctx.assert(err)
+				err = ctx.exec.Rename(t.src, t.dest)
+				ctx.assert(err)
+				err = ctx.exec.Chown(t.dest, glitterUser, glitterGroup, true)
+				ctx.assert(err)
+			}
+			return nil
+		}).
+		Do("Restart services", func(ctx *setupNodeCtx) error {
+			for _, unit := range active {
+				if err := ctx.exec.Systemctl(ctx.Context, "start", unit); err != nil {
+					return err
+				}
+			}
+			return nil
+		}).
+		Commit()
+	return p.Error()
+}
+
+// glitterDBPath returns the db_path of the installed glitter config.
+func glitterDBPath() (string, error) {
+	return readDBPath(pathJoin(installdir, "glitter", "config.toml"))
+}
+
+// readDBPath returns the db_path of the glitter config file config.
+func readDBPath(config string) (string, error) {
+	b, err := ioutil.ReadFile(config)
+	if err != nil {
+		return "", err
+	}
+	m := dbPathRe.FindSubmatch(b)
+	if m == nil {
+		return "", errors.Errorf("%s: db_path is not set", config)
+	}
+	dbPath := filepath.Clean(string(m[1]))
+	if !filepath.IsAbs(dbPath) || dbPath == "/" || dbPath == installdir {
+		return "", errors.Errorf("%s: refusing to restore into db_path %q", config, dbPath)
+	}
+	return dbPath, nil
+}
+
+// streamSnapshot extracts the snapshot at rawURL to the paths target
+// returns while it is downloaded, so that the archive is never stored, and
+// fails unless the whole stream matches digest.
+func streamSnapshot(ctx *setupNodeCtx, rawURL, digest string, target func(name string, isDir bool) (string, error)) error {
+	req, err := http.NewRequestWithContext(ctx.Context, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := ctx.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &httpStatusError{URL: rawURL, Status: resp.Status, Code: resp.StatusCode}
+	}
+
+	h := sha256.New()
+	pw := &progressWriter{url: rawURL, total: resp.ContentLength, report: ctx.report}
+	body := bufio.NewReader(io.TeeReader(resp.Body, io.MultiWriter(h, pw)))
+	r, err := decompressSnapshot(body)
+	if err == nil {
+		err = extractTarFunc(r, target)
+	}
+	if err == nil {
+		// Hash what follows the end of the tar stream too.
+		_, err = io.Copy(ioutil.Discard, body)
+	}
+	pw.finish(err == nil)
+	if err != nil {
+		return errors.Wrapf(err, "snapshot %s", rawURL)
+	}
+
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != digest {
+		return errors.Wrapf(ErrChecksumMismatch, "snapshot %s: expected sha256 %s, got %s", rawURL, digest, actual)
+	}
+	return nil
+}
+
+// decompressSnapshot detects a gzip or lz4 compressed or a plain tar stream.
+func decompressSnapshot(r *bufio.Reader) (io.Reader, error) {
+	magic, _ := r.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(r)
+	case bytes.Equal(magic, []byte{0x04, 0x22, 0x4d, 0x18}):
+		return lz4.NewReader(r), nil
+	}
+	// A tar header carries "ustar" at offset 257.
+	if hdr, _ := r.Peek(262); len(hdr) == 262 && bytes.Equal(hdr[257:], []byte("ustar")) {
+		return r, nil
+	}
+	return nil, errors.Wrap(ErrInvalidArgument, "not a tar.gz, tar.lz4 or tar archive")
+}
+
+// snapshotStager extracts each directory of a snapshot to a staging
+// directory next to the directory it replaces, so that installing it is a
+// rename within one filesystem, and refuses anything else.
+type snapshotStager struct {
+	ctx     *setupNodeCtx
+	tmData  string
+	dbPath  string
+	targets []snapshotTarget
+}
+
+// target returns the staged path of the snapshot entry name.
+func (s *snapshotStager) target(name string, isDir bool) (string, error) {
+	parts := strings.Split(filepath.ToSlash(name), "/")
+	var prefix, dest string
+	switch {
+	case parts[0] == ".":
+		return "", nil
+	case parts[0] == snapshotTendermintHome:
+		prefix, dest = snapshotTendermintHome, s.tmData
+		parts = parts[1:]
+	case parts[0] == "tendermint" && len(parts) == 1 && isDir:
+		return "", nil
+	case parts[0] == "tendermint":
+		if len(parts) == 1 {
+			return "", errors.Wrap(ErrInvalidArgument, "snapshot: tendermint is not a directory")
+		}
+		if parts[1] != "data" {
+			return "", errors.Wrapf(ErrInvalidArgument, "snapshot: unexpected entry tendermint/%s, expected %s/", parts[1], snapshotTendermintData)
+		}
+		prefix, dest = snapshotTendermintData, s.tmData
+		parts = parts[2:]
+	case parts[0] == snapshotGlitterDB:
+		prefix, dest = snapshotGlitterDB, s.dbPath
+		parts = parts[1:]
+	default:
+		return "", errors.Wrapf(ErrInvalidArgument, "snapshot: unexpected entry %s, expected %s/, %s/ or %s/",
+			parts[0], snapshotTendermintData, snapshotTendermintHome, snapshotGlitterDB)
+	}
+	if len(parts) == 0 && !isDir {
+		return "", errors.Wrapf(ErrInvalidArgument, "snapshot: %s is not a directory", name)
+	}
+
+	src, err := s.stage(prefix, dest)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{src}, parts...)...), nil
+}
+
+// stage returns the staging directory of dest, created on first use.
+func (s *snapshotStager) stage(prefix, dest string) (string, error) {
+	for _, t := range s.targets {
+		if t.dest != dest {
+			continue
+		}
+		if t.prefix != prefix {
+			return "", errors.Wrapf(ErrInvalidArgument, "snapshot has both %s/ and %s/", snapshotTendermintData, snapshotTendermintHome)
+		}
+		return t.src, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+	stage, err := ioutil.TempDir(filepath.Dir(dest), ".snapshot-")
+	if err != nil {
+		return "", err
+	}
+	s.ctx.onUndo("remove "+stage, func() error {
+		return os.RemoveAll(stage)
+	})
+	s.ctx.onCommit(func() error {
+		return os.RemoveAll(stage)
+	})
+	src := filepath.Join(stage, filepath.Base(dest))
+	if err := os.Mkdir(src, 0755); err != nil {
+		return "", err
+	}
+	s.targets = append(s.targets, snapshotTarget{src: src, dest: dest, prefix: prefix})
+	return src, nil
+}
@@ -0,0 +1,332 @@
+package glitterboot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pierrec/lz4/v4"
+	"github.com/pkg/errors"
+)
+
+// fakeSystemctl reports every unit inactive and succeeds otherwise.
+const fakeSystemctl = `#!/bin/sh
+if [ "$1" = is-active ]; then
+	echo inactive
+	exit 3
+fi
+exit 0
+`
+
+// useTestInstall points the install, boot and store directories to
+// temporary ones, owned by the current user, and puts a fake systemctl in
+// the PATH.
+func useTestInstall(t *testing.T) {
+	oldInstall, oldBoot, oldStore := installdir, bootdir, storedir
+	oldUser, oldGroup := glitterUser, glitterGroup
+	t.Cleanup(func() {
+		installdir, bootdir, storedir = oldInstall, oldBoot, oldStore
+		glitterUser, glitterGroup = oldUser, oldGroup
+	})
+	installdir = t.TempDir()
+	bootdir = t.TempDir()
+	storedir = filepath.Join(bootdir, "store.json")
+
+	u, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := user.LookupGroupId(u.Gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	glitterUser, glitterGroup = u.Username, g.Name
+
+	bin := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(bin, "systemctl"), []byte(fakeSystemctl), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// writeTree writes files, keyed by their slash separated path, under dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns the files under dir keyed by their slash separated path.
+func readTree(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(b)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// makeSnapshot returns a tar archive of files compressed with compression,
+// "gzip" or "lz4".
+func makeSnapshot(t *testing.T, compression string, files map[string]string) []byte {
+	var buf bytes.Buffer
+	var w interface {
+		Write(p []byte) (int, error)
+		Close() error
+	}
+	switch compression {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "lz4":
+		w = lz4.NewWriter(&buf)
+	default:
+		t.Fatalf("unknown compression %s", compression)
+	}
+	tw := tar.NewWriter(w)
+	for name, content := range files {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		if err == nil {
+			_, err = tw.Write([]byte(content))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+	installed := map[string]string{
+		"tendermint/data/blockstore.db/000001.ldb":  "old blocks",
+		"tendermint/data/priv_validator_state.json": "installed sign state",
+		"glitter/index/old.db":                      "old index",
+	}
+	snapshot := map[string]string{
+		"tendermint/data/blockstore.db/000002.ldb":  "new blocks",
+		"tendermint/data/priv_validator_state.json": "snapshot sign state",
+		"glitter/config.toml":                       "snapshot config",
+		"glitter/index/new.db":                      "new index",
+	}
+	restored := map[string]string{
+		"tendermint/data/blockstore.db/000002.ldb":  "new blocks",
+		"tendermint/data/priv_validator_state.json": "installed sign state",
+		"glitter/index/new.db":                      "new index",
+	}
+	homeSnapshot := map[string]string{}
+	for name, content := range snapshot {
+		homeSnapshot[strings.TrimPrefix(name, "tendermint/")] = content
+	}
+
+	tests := []struct {
+		name        string
+		compression string
+		files       map[string]string
+		sha256      func(archive []byte) string
+		err         error
+		errContains string
+	}{
+		{name: "tar.gz", compression: "gzip", files: snapshot},
+		{name: "tar.lz4", compression: "lz4", files: snapshot},
+		{name: "tendermint home layout", compression: "lz4", files: homeSnapshot},
+		{
+			name: "checksum mismatch", compression: "gzip", files: snapshot,
+			sha256: func([]byte) string { return sha256Hex([]byte("another archive")) },
+			err:    ErrChecksumMismatch,
+		},
+		{
+			name: "unexpected entry", compression: "gzip",
+			files: map[string]string{
+				"tendermint/data/blockstore.db/000002.ldb": "new blocks",
+				"etc/cron.d/job": "* * * * * root true",
+			},
+			err: ErrInvalidArgument, errContains: "unexpected entry etc",
+		},
+		{
+			name: "unexpected tendermint entry", compression: "gzip",
+			files: map[string]string{
+				"tendermint/data/blockstore.db/000002.ldb": "new blocks",
+				"tendermint/config/config.toml":            "config",
+			},
+			err: ErrInvalidArgument, errContains: "unexpected entry tendermint/config",
+		},
+		{
+			name: "both tendermint/data and data", compression: "lz4",
+			files: map[string]string{
+				"tendermint/data/blockstore.db/000002.ldb": "new blocks",
+				"data/blockstore.db/000003.ldb":            "other blocks",
+			},
+			err: ErrInvalidArgument, errContains: "both",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestInstall(t)
+			config := `db_path = "` + filepath.Join(installdir, "glitter") + `"` + "\n"
+			writeTree(t, installdir, installed)
+			writeTree(t, installdir, map[string]string{"glitter/config.toml": config})
+			before := readTree(t, installdir)
+
+			s, err := newFileStore(storedir, true)
+			if err == nil {
+				err = s.Set(keyInitDone, "true")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			archive := makeSnapshot(t, tt.compression, tt.files)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(archive)
+			}))
+			defer srv.Close()
+			digest := sha256Hex(archive)
+			if tt.sha256 != nil {
+				digest = tt.sha256(archive)
+			}
+
+			err = NodeOperate(context.Background(), NodeOpsArgs{
+				Type:           OpsRestoreSnapshot,
+				SnapshotURL:    srv.URL + "/snapshot",
+				SnapshotSHA256: digest,
+				Reporter:       NewTextReporter(ioutil.Discard),
+			})
+			after := readTree(t, installdir)
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) || !strings.Contains(err.Error(), tt.errContains) {
+					t.Fatalf("got %v, want %v containing %q", err, tt.err, tt.errContains)
+				}
+				if !equalTrees(before, after) {
+					t.Fatalf("installed files changed:\nbefore %v\nafter  %v", before, after)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := map[string]string{}
+			for name, content := range restored {
+				want[name] = content
+			}
+			// The installed glitter config is kept.
+			want["glitter/config.toml"] = config
+			if !equalTrees(want, after) {
+				t.Fatalf("got %v, want %v", after, want)
+			}
+		})
+	}
+}
+
+func equalTrees(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, content := range a {
+		if c, ok := b[name]; !ok || c != content {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRestoreSnapshotStagesNextToTargets(t *testing.T) {
+	useTestInstall(t)
+	// db_path outside of the install directory, as on a separate volume.
+	dbPath := filepath.Join(t.TempDir(), "glitter")
+	writeTree(t, installdir, map[string]string{
+		"tendermint/data/blockstore.db/000001.ldb": "old blocks",
+		"glitter/config.toml":                      `db_path = "` + dbPath + `"` + "\n",
+	})
+	writeTree(t, dbPath, map[string]string{"index/old.db": "old index"})
+	s, err := newFileStore(storedir, true)
+	if err == nil {
+		err = s.Set(keyInitDone, "true")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stager := &snapshotStager{tmData: pathJoin(installdir, "tendermint/data"), dbPath: dbPath}
+	err = runTestStep(func(ctx *setupNodeCtx) error {
+		stager.ctx = ctx
+		for _, name := range []string{"tendermint/data/blockstore.db/000002.ldb", "glitter/index/new.db"} {
+			if _, err := stager.target(filepath.FromSlash(name), false); err != nil {
+				return err
+			}
+		}
+		for _, tt := range stager.targets {
+			if filepath.Dir(filepath.Dir(tt.src)) != filepath.Dir(tt.dest) {
+				t.Errorf("%s staged in %s, not next to it", tt.dest, tt.src)
+			}
+			if err := os.RemoveAll(filepath.Dir(tt.src)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(stager.targets) != 2 {
+		t.Fatalf("got targets %v, want tendermint/data and db_path", stager.targets)
+	}
+
+	archive := makeSnapshot(t, "lz4", map[string]string{
+		"tendermint/data/blockstore.db/000002.ldb": "new blocks",
+		"glitter/index/new.db":                     "new index",
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer srv.Close()
+	err = NodeOperate(context.Background(), NodeOpsArgs{
+		Type:           OpsRestoreSnapshot,
+		SnapshotURL:    srv.URL + "/snapshot",
+		SnapshotSHA256: sha256Hex(archive),
+		Reporter:       NewTextReporter(ioutil.Discard),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := readTree(t, dbPath), map[string]string{"index/new.db": "new index"}; !equalTrees(got, want) {
+		t.Fatalf("got db_path %v, want %v", got, want)
+	}
+	for _, dir := range []string{installdir, filepath.Join(installdir, "tendermint"), filepath.Dir(dbPath)} {
+		staged, _ := filepath.Glob(filepath.Join(dir, ".snapshot-*"))
+		if len(staged) > 0 {
+			t.Fatalf("staging directories left behind: %v", staged)
+		}
+	}
+}
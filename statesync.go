@@ -0,0 +1,221 @@
+package glitterboot
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tendermint/tendermint/light"
+	"github.com/tendermint/tendermint/light/provider"
+	lighthttp "github.com/tendermint/tendermint/light/provider/http"
+	tmtypes "github.com/tendermint/tendermint/types"
+)
+
+const (
+	// stateSyncTrustOffset is how far below the latest block the trust
+	// height is taken, so that the cluster has snapshots above it.
+	stateSyncTrustOffset = 2000
+	defaultTrustPeriod   = 168 * time.Hour
+	// lightVerifyTimeout bounds the light client verification, which may
+	// fetch a few blocks per change of the validator set since genesis.
+	lightVerifyTimeout = 5 * time.Minute
+	lightMaxClockDrift = 10 * time.Second
+)
+
+// stateSyncTrust fills the [statesync] section of the tendermint config.
+type stateSyncTrust struct {
+	RPCServers []string `json:"rpc_servers"`
+	Height     int64    `json:"height"`
+	Hash       string   `json:"hash"`
+}
+
+// fetchStateSyncTrust picks two healthy cluster RPC servers and takes the
+// trust hash at height, or by default at the trust height of the first
+// one, from it. The header is verified as a light client does, from the
+// validators of the genesis, and the second server, the witness, must serve
+// the same header.
+func fetchStateSyncTrust(ctx *setupNodeCtx, height int64) (*stateSyncTrust, error) {
+	genesis, err := ctx.genesisDoc()
+	if os.IsNotExist(err) {
+		return nil, errors.Wrap(ErrNotInitialized, "state sync: the genesis is unknown")
+	}
+	ctx.assert(err)
+	chainID := genesis.ChainID
+
+	var (
+		servers []string
+		clients []*TendermintClient
+	)
+	for _, endpoint := range ctx.ClusterRPCs {
+		c, err := newTMClient(endpoint, ctx.rpcClient)
+		if err == nil {
+			err = checkRPCHealth(ctx.Context, c)
+		}
+		if err != nil {
+			ctx.warn("cluster RPC %s is unhealthy: %v", endpoint, err)
+			continue
+		}
+		servers = append(servers, endpoint)
+		clients = append(clients, c)
+		if len(servers) == 2 {
+			break
+		}
+	}
+	switch len(servers) {
+	case 0:
+		return nil, errors.New("state sync: no cluster RPC is healthy")
+	case 1:
+		// Tendermint wants two rpc_servers, they may be the same.
+		ctx.warn("state sync: %s is the only healthy cluster RPC, the trust hash is not cross-checked", servers[0])
+		servers = append(servers, servers[0])
+	}
+
+	if height == 0 {
+		status, err := clients[0].Status(ctx.Context)
+		if err != nil {
+			return nil, errors.Wrapf(err, "state sync: %s", servers[0])
+		}
+		height = status.SyncInfo.LatestBlockHeight - stateSyncTrustOffset
+		if height < genesis.InitialHeight {
+			height = genesis.InitialHeight
+		}
+	}
+
+	vctx, cancel := context.WithTimeout(ctx.Context, lightVerifyTimeout)
+	defer cancel()
+	primary := lighthttp.NewWithClient(chainID, clients[0])
+	var lb *tmtypes.LightBlock
+	if len(genesis.Validators) == 0 {
+		// The validators are set by the application in InitChain, there is
+		// no root of trust in the genesis.
+		ctx.warn("state sync: the genesis has no validators, the header at height %d is only checked to be consistent with the validators %s serves",
+			height, servers[0])
+		lb, err = consistentLightBlock(vctx, primary, height)
+	} else {
+		lb, err = verifyFromGenesis(vctx, primary, genesis, height)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "state sync: %s", servers[0])
+	}
+	hash := lb.Hash().String()
+
+	if len(clients) == 2 {
+		witness, err := lighthttp.NewWithClient(chainID, clients[1]).LightBlock(vctx, height)
+		if err != nil {
+			return nil, errors.Wrapf(err, "state sync: %s", servers[1])
+		}
+		if other := witness.Hash().String(); other != hash {
+			return nil, errors.Wrapf(ErrNetworkMismatch, "state sync: %s and %s serve different headers at height %d: %s, %s",
+				servers[0], servers[1], height, hash, other)
+		}
+	}
+	return &stateSyncTrust{RPCServers: servers, Height: height, Hash: hash}, nil
+}
+
+// genesisValidatorSet returns the validator set of the genesis.
+func genesisValidatorSet(genesis *tmtypes.GenesisDoc) (*tmtypes.ValidatorSet, error) {
+	vals := make([]*tmtypes.Validator, len(genesis.Validators))
+	for i, v := range genesis.Validators {
+		vals[i] = tmtypes.NewValidator(v.PubKey, v.Power)
+	}
+	set := tmtypes.NewValidatorSet(vals)
+	if err := set.ValidateBasic(); err != nil {
+		return nil, errors.Errorf("genesis validators: %v", err)
+	}
+	return set, nil
+}
+
+// verifyFromGenesis returns the light block at height once it is verified
+// from the validators of the genesis. The first block must be signed by the
+// genesis validators, then the chain is followed with skipping verification:
+// a block is trusted when more than 1/3 of the validators of the last trusted
+// block signed it, otherwise a block half way to it is verified first.
+//
+// The genesis validators are trusted whatever their age, as by a node
+// replaying the chain from genesis, so trusted blocks never expire here:
+// the trust period only applies to the light client of the node, from the
+// verified trust height on.
+func verifyFromGenesis(ctx context.Context, p provider.Provider, genesis *tmtypes.GenesisDoc, height int64) (*tmtypes.LightBlock, error) {
+	vals, err := genesisValidatorSet(genesis)
+	if err != nil {
+		return nil, err
+	}
+	trusted, err := p.LightBlock(ctx, genesis.InitialHeight)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(vals.Hash(), trusted.ValidatorsHash) {
+		return nil, errors.Errorf("the validators of block %d are not the validators of the genesis", trusted.Height)
+	}
+	if err := vals.VerifyCommitLight(genesis.ChainID, trusted.Commit.BlockID, trusted.Height, trusted.Commit); err != nil {
+		return nil, errors.Errorf("block %d is not signed by the genesis validators: %v", trusted.Height, err)
+	}
+	trusted.ValidatorSet = vals
+
+	// Long enough for the first block not to expire, later blocks are newer.
+	now := time.Now()
+	period := now.Sub(trusted.Time) + lightMaxClockDrift
+	pivot := height
+	for trusted.Height < height {
+		untrusted, err := p.LightBlock(ctx, pivot)
+		if err != nil {
+			return nil, err
+		}
+		err = light.Verify(trusted.SignedHeader, trusted.ValidatorSet, untrusted.SignedHeader, untrusted.ValidatorSet,
+			period, now, lightMaxClockDrift, light.DefaultTrustLevel)
+		var cantTrust light.ErrNewValSetCantBeTrusted
+		switch {
+		case err == nil:
+			trusted, pivot = untrusted, height
+		case errors.As(err, &cantTrust) && pivot > trusted.Height+1:
+			pivot = trusted.Height + (pivot-trusted.Height)/2
+		default:
+			return nil, errors.Errorf("block %d: %v", pivot, err)
+		}
+	}
+	return trusted, nil
+}
+
+// consistentLightBlock returns the light block at height once its commit
+// verifies against the validator set served with it. This only shows that
+// the server is consistent, it could have made up both.
+func consistentLightBlock(ctx context.Context, p provider.Provider, height int64) (*tmtypes.LightBlock, error) {
+	lb, err := p.LightBlock(ctx, height)
+	if err != nil {
+		return nil, err
+	}
+	if err := lb.ValidatorSet.VerifyCommitLight(lb.ChainID, lb.Commit.BlockID, height, lb.Commit); err != nil {
+		return nil, errors.Errorf("commit at height %d: %v", height, err)
+	}
+	return lb, nil
+}
+
+// stateSyncConfig returns the template data of the [statesync] section.
+func stateSyncConfig(ctx *setupNodeCtx) (map[string]interface{}, error) {
+	data := map[string]interface{}{
+		"StateSync":   false,
+		"RPCServers":  "",
+		"TrustHeight": int64(0),
+		"TrustHash":   "",
+		"TrustPeriod": ctx.TrustPeriod.String(),
+	}
+	if !ctx.StateSync {
+		return data, nil
+	}
+	trust := ctx.stateSync
+	if trust == nil {
+		var err error
+		trust, err = fetchStateSyncTrust(ctx, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	data["StateSync"] = true
+	data["RPCServers"] = strings.Join(trust.RPCServers, ",")
+	data["TrustHeight"] = trust.Height
+	data["TrustHash"] = trust.Hash
+	return data, nil
+}
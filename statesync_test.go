@@ -0,0 +1,145 @@
+package glitterboot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tendermint/tendermint/crypto/tmhash"
+	"github.com/tendermint/tendermint/light/provider"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+	tmversion "github.com/tendermint/tendermint/proto/tendermint/version"
+	tmtypes "github.com/tendermint/tendermint/types"
+	"github.com/tendermint/tendermint/version"
+)
+
+const testChainID = "glitter-test"
+
+// testChain is a provider serving light blocks signed by validator sets
+// which change at given heights.
+type testChain map[int64]*tmtypes.LightBlock
+
+func (c testChain) ChainID() string { return testChainID }
+
+func (c testChain) LightBlock(ctx context.Context, height int64) (*tmtypes.LightBlock, error) {
+	lb, ok := c[height]
+	if !ok {
+		return nil, provider.ErrLightBlockNotFound
+	}
+	return lb, nil
+}
+
+func (c testChain) ReportEvidence(context.Context, tmtypes.Evidence) error { return nil }
+
+type testValidators struct {
+	set   *tmtypes.ValidatorSet
+	privs []tmtypes.PrivValidator
+}
+
+func newTestValidators(n int) testValidators {
+	set, privs := tmtypes.RandValidatorSet(n, 10)
+	return testValidators{set, privs}
+}
+
+// signedBlock returns the light block at height signed by vals, with next
+// as the validators of the next block.
+func signedBlock(t *testing.T, height int64, tm time.Time, vals, next testValidators) *tmtypes.LightBlock {
+	h := &tmtypes.Header{
+		Version:            tmversion.Consensus{Block: version.BlockProtocol},
+		ChainID:            testChainID,
+		Height:             height,
+		Time:               tm,
+		ValidatorsHash:     vals.set.Hash(),
+		NextValidatorsHash: next.set.Hash(),
+		ConsensusHash:      tmhash.Sum([]byte("consensus")),
+		ProposerAddress:    vals.set.Proposer.Address,
+	}
+	blockID := tmtypes.BlockID{
+		Hash:          h.Hash(),
+		PartSetHeader: tmtypes.PartSetHeader{Total: 1, Hash: tmhash.Sum([]byte("parts"))},
+	}
+	voteSet := tmtypes.NewVoteSet(testChainID, height, 0, tmproto.PrecommitType, vals.set)
+	commit, err := tmtypes.MakeCommit(blockID, height, 0, voteSet, vals.privs, tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tmtypes.LightBlock{
+		SignedHeader: &tmtypes.SignedHeader{Header: h, Commit: commit},
+		ValidatorSet: vals.set,
+	}
+}
+
+// newTestChain returns a chain of blocks 1 to top ending a minute ago,
+// with the validators of each height given by valsAt.
+func newTestChain(t *testing.T, top int64, valsAt func(int64) testValidators) testChain {
+	c := testChain{}
+	start := time.Now().Add(-time.Duration(top+1) * time.Minute)
+	for h := int64(1); h <= top; h++ {
+		c[h] = signedBlock(t, h, start.Add(time.Duration(h)*time.Minute), valsAt(h), valsAt(h+1))
+	}
+	return c
+}
+
+func testGenesis(vals testValidators) *tmtypes.GenesisDoc {
+	doc := &tmtypes.GenesisDoc{ChainID: testChainID, InitialHeight: 1}
+	for _, v := range vals.set.Validators {
+		doc.Validators = append(doc.Validators, tmtypes.GenesisValidator{PubKey: v.PubKey, Power: v.VotingPower})
+	}
+	return doc
+}
+
+func TestVerifyFromGenesis(t *testing.T) {
+	genesisVals := newTestValidators(4)
+	nextVals := newTestValidators(4)
+	otherVals := newTestValidators(4)
+
+	tests := []struct {
+		name    string
+		genesis testValidators
+		chain   func() testChain
+		ok      bool
+	}{
+		{"same validators", genesisVals, func() testChain {
+			return newTestChain(t, 100, func(int64) testValidators { return genesisVals })
+		}, true},
+		{"validators replaced", genesisVals, func() testChain {
+			return newTestChain(t, 100, func(h int64) testValidators {
+				if h < 37 {
+					return genesisVals
+				}
+				return nextVals
+			})
+		}, true},
+		{"forged chain", genesisVals, func() testChain {
+			// Consistent with the validators served, but not signed by
+			// the genesis validators.
+			return newTestChain(t, 100, func(int64) testValidators { return otherVals })
+		}, false},
+		{"forged target", genesisVals, func() testChain {
+			c := newTestChain(t, 100, func(int64) testValidators { return genesisVals })
+			c[100] = signedBlock(t, 100, c[100].Time, otherVals, otherVals)
+			return c
+		}, false},
+		{"other genesis", otherVals, func() testChain {
+			return newTestChain(t, 100, func(int64) testValidators { return genesisVals })
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := tt.chain()
+			lb, err := verifyFromGenesis(context.Background(), chain, testGenesis(tt.genesis), 100)
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("forged header accepted")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got, want := lb.Hash(), chain[100].Hash(); got.String() != want.String() {
+				t.Fatalf("got header %s, want %s", got, want)
+			}
+		})
+	}
+}
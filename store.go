@@ -64,3 +64,28 @@ func (s *fileStore) Set(key, value string) error {
 	}
 	return nil
 }
+
+var _ store = new(dryRunStore)
+
+// dryRunStore reads through to the underlying store but keeps writes in
+// memory, so a dry run never changes store.json.
+type dryRunStore struct {
+	s store
+	m map[string]string
+}
+
+func newDryRunStore(s store) *dryRunStore {
+	return &dryRunStore{s: s, m: map[string]string{}}
+}
+
+func (s *dryRunStore) Get(key string) (string, error) {
+	if v, ok := s.m[key]; ok {
+		return v, nil
+	}
+	return s.s.Get(key)
+}
+
+func (s *dryRunStore) Set(key, value string) error {
+	s.m[key] = value
+	return nil
+}
@@ -1,23 +1,24 @@
 package glitterboot
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"strings"
+	"time"
 
-	tmjson "github.com/tendermint/tendermint/libs/json"
 	tmos "github.com/tendermint/tendermint/libs/os"
 )
 
 // ==== commands ====
 
-func systemctl(args ...string) error {
-	return exec.Command("systemctl", args...).Run()
+func systemctl(ctx context.Context, args ...string) error {
+	return exec.CommandContext(ctx, "systemctl", args...).Run()
 }
 
 func systemctlOut(args ...string) (string, error) {
@@ -39,41 +40,30 @@ func copyFile(d CopyFileDesc) error {
 	return tmos.CopyFile(d.Src, d.Dest)
 }
 
-func jsonToFile(v interface{}, filename string) error {
-	b, err := tmjson.Marshal(v)
+// httpGet returns the body of url, which is expected to be small.
+func httpGet(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return err
-	}
-	return ioutil.WriteFile(filename, b, 0644)
-}
-
-func downloadFile(filepath string, url string) (err error) {
-	// Create the file
-	out, err := os.Create(filepath)
-	if err != nil {
-		return err
+		return nil, err
 	}
-	defer out.Close()
-
-	// Get the data
-	resp, err := http.Get(url)
+	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	// Check server response
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
+		return nil, &httpStatusError{URL: url, Status: resp.Status, Code: resp.StatusCode}
 	}
+	return ioutil.ReadAll(resp.Body)
+}
 
-	// Writer the body to file
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return err
+// readSource reads src from an http(s) URL or a local path.
+func readSource(ctx context.Context, client *http.Client, src string) ([]byte, error) {
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		return httpGet(ctx, client, src)
 	}
-
-	return nil
+	return ioutil.ReadFile(src)
 }
 
 func pathJoin(elem ...string) string {
@@ -105,3 +95,15 @@ func checkUserGroup(u, grp string) error {
 	}
 	return nil
 }
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}